@@ -0,0 +1,139 @@
+package common
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Resample draws n indices in [0,n) with replacement
+func Resample(rnd *rand.Rand, n int) []int {
+	idx := make([]int, n)
+	for i := range idx {
+		idx[i] = rnd.Intn(n)
+	}
+	return idx
+}
+
+// Percentile of the values by linear interpolation, p in [0,1]
+func Percentile(vals []float64, p float64) float64 {
+	if len(vals) == 0 {
+		return math.NaN()
+	}
+	sorted := make([]float64, len(vals))
+	copy(sorted, vals)
+	sort.Float64s(sorted)
+
+	pos := p * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// PValue is the two-sided bootstrap significance of the observed sign
+// of a difference, the fraction of resampled differences that cross zero
+func PValue(deltas []float64) float64 {
+	if len(deltas) == 0 {
+		return math.NaN()
+	}
+	le, ge := 0, 0
+	for _, d := range deltas {
+		if d <= 0 {
+			le++
+		}
+		if d >= 0 {
+			ge++
+		}
+	}
+	p := 2 * math.Min(float64(le), float64(ge)) / float64(len(deltas))
+	return math.Min(p, 1)
+}
+
+// GetInterval is the central interval holding level, eg. .95, of the
+// values. Undefined values make the interval undefined under UndefinedNA,
+// and are otherwise left out.
+func GetInterval(vals []float64, level float64, u Undefined) Interval {
+	defined := make([]float64, 0, len(vals))
+	for _, v := range vals {
+		if !math.IsNaN(v) {
+			defined = append(defined, v)
+		} else if u == UndefinedNA {
+			return Interval{Lo: Metric(math.NaN()), Hi: Metric(math.NaN())}
+		}
+	}
+	return Interval{
+		Lo: Metric(Percentile(defined, (1-level)/2)),
+		Hi: Metric(Percentile(defined, (1+level)/2)),
+	}
+}
+
+// BootstrapIntervals estimates confidence intervals of the per-class metrics
+// and the mAP by resampling the scenes with replacement.  The confusion
+// matrices and results are of the same scenes, in the same order.  Every
+// class with truth in any scene has intervals, and the metrics of a class
+// are undefined in the samples without any of its truth, which are counted
+// and handled by u.
+func BootstrapIntervals(cms []ConfusionMatrix, results []SceneResult, samples int, level float64, u Undefined, rnd *rand.Rand) (map[CID]ClassIntervals, Interval) {
+	classes := make(map[CID]bool)
+	for _, k := range MergeConfusionMatrices(cms...).Classes() {
+		classes[k] = true
+	}
+	for _, r := range results {
+		for k, cnt := range r.Truth {
+			if cnt > 0 {
+				classes[k] = true
+			}
+		}
+	}
+
+	prec := make(map[CID][]float64)
+	rec := make(map[CID][]float64)
+	f1 := make(map[CID][]float64)
+	ap := make(map[CID][]float64)
+	undefined := make(map[CID]int)
+	maps := make([]float64, 0, samples)
+
+	for i := 0; i < samples; i++ {
+		idx := Resample(rnd, len(cms))
+		xc := make([]ConfusionMatrix, len(idx))
+		xr := make([]SceneResult, len(idx))
+		for j, k := range idx {
+			xc[j], xr[j] = cms[k], results[k]
+		}
+
+		c := MergeConfusionMatrices(xc...)
+		curves := GetSceneCurves(xr)
+		for k := range classes {
+			if c[k].T == 0 {
+				undefined[k]++
+				nan := u.Resolve(math.NaN())
+				prec[k] = append(prec[k], nan)
+				rec[k] = append(rec[k], nan)
+				f1[k] = append(f1[k], nan)
+				ap[k] = append(ap[k], nan)
+				continue
+			}
+			prec[k] = append(prec[k], u.Resolve(GetPrecision(k, c)))
+			rec[k] = append(rec[k], u.Resolve(GetRecall(k, c)))
+			f1[k] = append(f1[k], u.Resolve(GetF1Score(k, c)))
+			if curve, ok := curves[k]; ok {
+				ap[k] = append(ap[k], u.Resolve(curve.AveragePrecision()))
+			} else {
+				ap[k] = append(ap[k], u.Resolve(math.NaN()))
+			}
+		}
+		maps = append(maps, MeanAP(curves))
+	}
+
+	ret := make(map[CID]ClassIntervals, len(classes))
+	for k := range classes {
+		ret[k] = ClassIntervals{
+			Precision: GetInterval(prec[k], level, u),
+			Recall:    GetInterval(rec[k], level, u),
+			F1:        GetInterval(f1[k], level, u),
+			AP:        GetInterval(ap[k], level, u),
+			Undefined: undefined[k],
+		}
+	}
+	return ret, GetInterval(maps, level, u)
+}
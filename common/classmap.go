@@ -0,0 +1,100 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// marks a class as ignored in a ClassMap
+const IgnoreClass CID = -1
+
+// remaps classes, eg. to merge them or to ignore classes that a model was
+// not trained on. Classes that are not in the map are kept as is.
+type ClassMap map[CID]CID
+
+// ReadClassMap reads a class mapping file of `class target' lines, where
+// target is the class to remap to, or `ignore'. Blank lines and lines
+// starting with # are ignored.
+func ReadClassMap(classmapFile string) (ClassMap, error) {
+	file, err := os.Open(classmapFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ret := make(ClassMap)
+	err = ReadLines(file, classmapFile, Strict, func(line string) error {
+		splits := Fields(line)
+		if len(splits) != 2 {
+			return fmt.Errorf("expected `class target'")
+		}
+		class, err := strconv.Atoi(splits[0])
+		if err != nil {
+			return err
+		}
+		target := IgnoreClass
+		if splits[1] != "ignore" {
+			t, err := strconv.Atoi(splits[1])
+			if err != nil || t < 0 {
+				return fmt.Errorf("target must be a class or ignore")
+			}
+			target = CID(t)
+		}
+		ret[CID(class)] = target
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Class returns the class that class maps to, and false when it is ignored
+func (m ClassMap) Class(class CID) (CID, bool) {
+	if t, ok := m[class]; ok {
+		return t, t != IgnoreClass
+	}
+	return class, true
+}
+
+// MapTruth returns a copy of truth with classes remapped, truth of ignored
+// classes is kept with its class but marked Ignore
+func (m ClassMap) MapTruth(truth []Truth) []Truth {
+	ret := make([]Truth, len(truth))
+	for i, t := range truth {
+		if c, ok := m.Class(t.Class); ok {
+			t.Class = c
+		} else {
+			t.Ignore = true
+		}
+		ret[i] = t
+	}
+	return ret
+}
+
+// MapDetects returns a copy of detects with classes remapped, dropping
+// detections of ignored classes
+func (m ClassMap) MapDetects(detects []Detect) []Detect {
+	ret := make([]Detect, 0, len(detects))
+	for _, d := range detects {
+		if c, ok := m.Class(d.Class); ok {
+			d.Class = c
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+// Apply the mapping to the truth and detections of each scene
+func (m ClassMap) Apply(scenes []Scene) []Scene {
+	ret := make([]Scene, len(scenes))
+	for i, s := range scenes {
+		ret[i] = Scene{
+			Name:    s.Name,
+			Truth:   m.MapTruth(s.Truth),
+			Detects: m.MapDetects(s.Detects),
+		}
+	}
+	return ret
+}
@@ -5,18 +5,20 @@
 package common
 
 import (
-	"bytes"
+	"encoding/csv"
 	"fmt"
+	"io"
+	"math"
 	"sort"
-	"text/tabwriter"
+	"strconv"
 )
 
 const FP CID = 0
 
 // Truth to predictions
 type Tp struct {
-	T int
-	P map[CID]int
+	T int         `json:"truth"`
+	P map[CID]int `json:"predicted"`
 }
 
 type ConfusionMatrix map[CID]Tp
@@ -50,14 +52,136 @@ func GetConfusionMatrix(t map[CID]int, p map[TID]Match, fp map[CID]int) (Confusi
 	return ret, nil
 }
 
+// Add accumulates the counts of another matrix into this one
+func (c ConfusionMatrix) Add(o ConfusionMatrix) {
+	for cid, tp := range o {
+		m, ok := c[cid]
+		if !ok || m.P == nil {
+			m.P = make(map[CID]int)
+		}
+		m.T += tp.T
+		for pid, cnt := range tp.P {
+			m.P[pid] += cnt
+		}
+		c[cid] = m
+	}
+}
+
+// MergeConfusionMatrices sums the matrices of separately scored scenes
+func MergeConfusionMatrices(cms ...ConfusionMatrix) ConfusionMatrix {
+	ret := make(ConfusionMatrix)
+	for _, c := range cms {
+		ret.Add(c)
+	}
+	return ret
+}
+
+// Undefined selects how a metric that would divide by zero, such as the
+// precision of a class that was never predicted, is reported
+type Undefined int
+
+const (
+	// reported as not available, and any average including it is too
+	UndefinedNA Undefined = iota
+	// reported as zero, and averaged as zero
+	UndefinedZero
+	// reported as not available, and left out of averages
+	UndefinedSkip
+)
+
+var undefinedNames = map[string]Undefined{
+	"na":   UndefinedNA,
+	"zero": UndefinedZero,
+	"skip": UndefinedSkip,
+}
+
+// ParseUndefined parses one of na, zero or skip
+func ParseUndefined(s string) (Undefined, error) {
+	if u, ok := undefinedNames[s]; ok {
+		return u, nil
+	}
+	return UndefinedNA, fmt.Errorf("unknown undefined metric handling %q, expected na, zero or skip", s)
+}
+
+// Resolve a metric for reporting, undefined metrics are NaN
+func (u Undefined) Resolve(v float64) float64 {
+	if math.IsNaN(v) && u == UndefinedZero {
+		return 0
+	}
+	return v
+}
+
+// Mean averages metrics under the handling of undefined values, the mean
+// of no values is undefined
+func (u Undefined) Mean(vals []float64, weights []float64) float64 {
+	sum, total := 0.0, 0.0
+	for i, v := range vals {
+		w := 1.0
+		if weights != nil {
+			w = weights[i]
+		}
+		if math.IsNaN(v) {
+			switch u {
+			case UndefinedNA:
+				return math.NaN()
+			case UndefinedSkip:
+				continue
+			}
+			v = 0
+		}
+		sum += v * w
+		total += w
+	}
+	return ratio(sum, total)
+}
+
+// a ratio that is NaN, undefined, when the denominator is zero
+func ratio(n, d float64) float64 {
+	if d == 0 {
+		return math.NaN()
+	}
+	return n / d
+}
+
+// Classes returns the truth classes of the matrix, excluding the FP background
+func (c ConfusionMatrix) Classes() []CID {
+	keys := make([]int, 0, len(c))
+	for k := range c {
+		if k != FP {
+			keys = append(keys, int(k))
+		}
+	}
+	sort.Ints(keys)
+
+	ret := make([]CID, len(keys))
+	for i, k := range keys {
+		ret[i] = CID(k)
+	}
+	return ret
+}
+
+// GetTotal returns the number of entries in the matrix, every truth
+// along with every false positive against the background
+func GetTotal(c ConfusionMatrix) float64 {
+	ret := 0
+	for _, tp := range c {
+		ret += tp.T
+	}
+	return float64(ret)
+}
+
 // GetTruePositives returns the number of times an entry is
 // predicted successfully in a given ConfusionMatrix.
 func GetTruePositives(class CID, c ConfusionMatrix) float64 {
+	if class == FP {
+		return 0
+	}
 	return float64(c[class].P[class])
 }
 
 // GetFalsePositives returns the number of times an entry is
-// incorrectly predicted as having a given class.
+// incorrectly predicted as having a given class, including
+// predictions of the class against the background.
 func GetFalsePositives(class CID, c ConfusionMatrix) float64 {
 	ret := 0
 	for k := range c {
@@ -65,77 +189,67 @@ func GetFalsePositives(class CID, c ConfusionMatrix) float64 {
 			ret += c[k].P[class]
 		}
 	}
-	ret += c[0].P[class]
 	return float64(ret)
 }
 
 // GetFalseNegatives returns the number of times an entry is
-// incorrectly predicted as something other than the given class.
+// predicted as something other than the given class, or missed.
 func GetFalseNegatives(class CID, c ConfusionMatrix) float64 {
-	ret := 0
-	for k := range c[class].P {
-		if k != class {
-			ret += c[class].P[k]
-		}
+	if class == FP {
+		return 0
 	}
-	return float64(ret)
+	return float64(c[class].T) - GetTruePositives(class, c)
 }
 
 // GetTrueNegatives returns the number of times an entry is
-// correctly predicted as something other than the given class.
+// correctly predicted as something other than the given class,
+// including other truth that was missed.
 func GetTrueNegatives(class CID, c ConfusionMatrix) float64 {
-	ret := 0
-	for k := range c {
-		if k != class {
-			for l := range c[k].P {
-				if l != class {
-					ret += c[k].P[l]
-				}
-			}
-		}
-	}
-	return float64(ret)
+	return GetTotal(c) - GetTruePositives(class, c) - GetFalsePositives(class, c) - GetFalseNegatives(class, c)
 }
 
 // GetPrecision returns the fraction of of the total predictions
-// for a given class which were correct.
+// for a given class which were correct, undefined when the class
+// was never predicted.
 func GetPrecision(class CID, c ConfusionMatrix) float64 {
 	// Fraction of retrieved instances that are relevant
 	truePositives := GetTruePositives(class, c)
 	falsePositives := GetFalsePositives(class, c)
-	return truePositives / (truePositives + falsePositives)
+	return ratio(truePositives, truePositives+falsePositives)
 }
 
 // GetRecall returns the fraction of the total occurrences of a
-// given class which were predicted.
+// given class which were predicted, undefined when there are none.
 func GetRecall(class CID, c ConfusionMatrix) float64 {
 	// Fraction of relevant instances that are retrieved
 	truePositives := GetTruePositives(class, c)
-	return truePositives / float64(c[class].T)
+	return ratio(truePositives, float64(c[class].T))
 }
 
 // GetF1Score computes the harmonic mean of precision and recall
-// (equivalently called F-measure)
+// (equivalently called F-measure), undefined when either is and
+// zero when both are zero
 func GetF1Score(class CID, c ConfusionMatrix) float64 {
 	precision := GetPrecision(class, c)
 	recall := GetRecall(class, c)
+	if math.IsNaN(precision) || math.IsNaN(recall) {
+		return math.NaN()
+	}
+	if precision+recall == 0 {
+		return 0
+	}
 	return 2 * (precision * recall) / (precision + recall)
 }
 
 // GetAccuracy computes the overall classification accuracy
-// That is (number of correctly classified instances) / total instances
+// That is (number of correctly classified instances) / total instances,
+// where false positives and missed truth are incorrect instances
 func GetAccuracy(c ConfusionMatrix) float64 {
-	correct := 0
-	total := 0
-	for i := range c {
-		for j := range c[i].P {
-			if i == j {
-				correct += c[i].P[j]
-			}
-			total += c[i].T
-		}
+	correct := 0.0
+	for _, k := range c.Classes() {
+		correct += GetTruePositives(k, c)
 	}
-	return float64(correct) / float64(total)
+	return ratio(correct, GetTotal(c))
 }
 
 // GetMicroPrecision assesses Classifier performance across
@@ -143,21 +257,18 @@ func GetAccuracy(c ConfusionMatrix) float64 {
 func GetMicroPrecision(c ConfusionMatrix) float64 {
 	truePositives := 0.0
 	falsePositives := 0.0
-	for k := range c {
+	for _, k := range predictedClasses(c) {
 		truePositives += GetTruePositives(k, c)
 		falsePositives += GetFalsePositives(k, c)
 	}
-	return truePositives / (truePositives + falsePositives)
+	return ratio(truePositives, truePositives+falsePositives)
 }
 
 // GetMacroPrecision assesses Classifier performance across all
-// classes by averaging the precision measures achieved for each class.
-func GetMacroPrecision(c ConfusionMatrix) float64 {
-	precisionVals := 0.0
-	for k := range c {
-		precisionVals += GetPrecision(k, c)
-	}
-	return precisionVals / float64(len(c))
+// classes by averaging the precision measures achieved for each class,
+// including those that are only predicted.
+func GetMacroPrecision(c ConfusionMatrix, u Undefined) float64 {
+	return u.Mean(perClass(c, predictedClasses(c), GetPrecision), nil)
 }
 
 // GetMicroRecall assesses Classifier performance across all
@@ -165,58 +276,243 @@ func GetMacroPrecision(c ConfusionMatrix) float64 {
 func GetMicroRecall(c ConfusionMatrix) float64 {
 	truePositives := 0.0
 	falseNegatives := 0.0
-	for k := range c {
+	for _, k := range c.Classes() {
 		truePositives += GetTruePositives(k, c)
 		falseNegatives += GetFalseNegatives(k, c)
 	}
-	return truePositives / (truePositives + falseNegatives)
+	return ratio(truePositives, truePositives+falseNegatives)
 }
 
 // GetMacroRecall assesses Classifier performance across all classes
 // by averaging the recall measures achieved for each class
-func GetMacroRecall(c ConfusionMatrix) float64 {
-	recallVals := 0.0
-	for k := range c {
-		recallVals += GetRecall(k, c)
+func GetMacroRecall(c ConfusionMatrix, u Undefined) float64 {
+	return u.Mean(perClass(c, c.Classes(), GetRecall), nil)
+}
+
+// GetSpecificity returns the fraction of entries that are not
+// of a given class which were not predicted as that class.
+func GetSpecificity(class CID, c ConfusionMatrix) float64 {
+	trueNegatives := GetTrueNegatives(class, c)
+	falsePositives := GetFalsePositives(class, c)
+	return ratio(trueNegatives, trueNegatives+falsePositives)
+}
+
+// GetMicroF1 computes the harmonic mean of the micro precision and recall
+func GetMicroF1(c ConfusionMatrix) float64 {
+	precision := GetMicroPrecision(c)
+	recall := GetMicroRecall(c)
+	if math.IsNaN(precision) || math.IsNaN(recall) {
+		return math.NaN()
+	}
+	if precision+recall == 0 {
+		return 0
 	}
-	return recallVals / float64(len(c))
+	return 2 * (precision * recall) / (precision + recall)
+}
+
+// GetMacroF1 averages the F1 score of each class
+func GetMacroF1(c ConfusionMatrix, u Undefined) float64 {
+	return u.Mean(perClass(c, c.Classes(), GetF1Score), nil)
+}
+
+// GetWeightedF1 averages the F1 score of each class weighted by its
+// support, the number of truth entries
+func GetWeightedF1(c ConfusionMatrix, u Undefined) float64 {
+	classes := c.Classes()
+	weights := make([]float64, len(classes))
+	for i, k := range classes {
+		weights[i] = float64(c[k].T)
+	}
+	return u.Mean(perClass(c, c.Classes(), GetF1Score), weights)
+}
+
+// GetBalancedAccuracy averages the recall of each class
+func GetBalancedAccuracy(c ConfusionMatrix, u Undefined) float64 {
+	return GetMacroRecall(c, u)
+}
+
+// a metric of each of the classes, in their order
+func perClass(c ConfusionMatrix, classes []CID, f func(CID, ConfusionMatrix) float64) []float64 {
+	ret := make([]float64, len(classes))
+	for i, k := range classes {
+		ret[i] = f(k, c)
+	}
+	return ret
+}
+
+// every class that is truth or predicted, excluding the background
+func predictedClasses(c ConfusionMatrix) []CID {
+	m := GetMatrixCells(c)
+	return m.Classes
+}
+
+// square matrix of the cells, with the background row paired
+// to the missed column, and its row and column totals
+func squareCells(c ConfusionMatrix) ([][]int, []float64, []float64, float64) {
+	m := GetMatrixCells(c)
+	rows := make([]float64, len(m.Cells))
+	cols := make([]float64, len(m.Cells))
+	total := 0.0
+	for i, row := range m.Cells {
+		for j, v := range row {
+			rows[i] += float64(v)
+			cols[j] += float64(v)
+			total += float64(v)
+		}
+	}
+	return m.Cells, rows, cols, total
+}
+
+// GetKappa computes Cohen's kappa, the agreement between truth and
+// predictions beyond what is expected by chance, treating background
+// as a class
+func GetKappa(c ConfusionMatrix) float64 {
+	cells, rows, cols, total := squareCells(c)
+	observed := 0.0
+	expected := 0.0
+	for i := range cells {
+		observed += float64(cells[i][i])
+		expected += rows[i] * cols[i]
+	}
+	observed = ratio(observed, total)
+	expected = ratio(expected, total*total)
+	return ratio(observed-expected, 1-expected)
+}
+
+// GetMCC computes the multiclass Matthews correlation coefficient,
+// treating background as a class
+func GetMCC(c ConfusionMatrix) float64 {
+	cells, rows, cols, total := squareCells(c)
+	correct := 0.0
+	pt, pp, tt := 0.0, 0.0, 0.0
+	for i := range cells {
+		correct += float64(cells[i][i])
+		pt += cols[i] * rows[i]
+		pp += cols[i] * cols[i]
+		tt += rows[i] * rows[i]
+	}
+	return ratio(correct*total-pt, math.Sqrt((total*total-pp)*(total*total-tt)))
 }
 
 // GetSummary returns a table of precision, recall, true positive,
 // false positive, and true negatives for each class for a given
-// ConfusionMatrix
+// ConfusionMatrix, leaving undefined metrics out of averages
 func GetSummary(c ConfusionMatrix) string {
-	var buffer bytes.Buffer
-	w := new(tabwriter.Writer)
-	w.Init(&buffer, 0, 8, 0, '\t', 0)
+	return GetReport(c, nil, nil, UndefinedSkip).Summary()
+}
 
-	fmt.Fprintln(w, "Reference Class\tTruth\tTrue Positives\tFalse Positives\tTrue Negatives\tFalse Negatives\tPrecision\tRecall\tF1 Score")
-	fmt.Fprintln(w, "---------------\t-----\t--------------\t---------------\t--------------\t---------\t---------\t------\t--------")
+// the cells of a ConfusionMatrix, truth rows by predicted columns, with a
+// last background row of false positives and a last column of missed truth
+type MatrixCells struct {
+	Classes []CID
+	Cells   [][]int
+}
 
-	keys := make([]int, 0, len(c))
-	for k := range c {
-		if k != 0 {
-			keys = append(keys, int(k))
+// GetMatrixCells lays out every class that is either truth or predicted
+func GetMatrixCells(c ConfusionMatrix) MatrixCells {
+	seen := make(map[CID]bool)
+	for k, tp := range c {
+		if k != FP {
+			seen[k] = true
 		}
+		for p := range tp.P {
+			seen[p] = true
+		}
+	}
+	keys := make([]int, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, int(k))
 	}
 	sort.Ints(keys)
 
-	for _, ik := range keys {
-		k := CID(ik)
-		//==============================
-		t := c[k].T
-		tp := GetTruePositives(k, c)
-		fp := GetFalsePositives(k, c)
-		tn := GetTrueNegatives(k, c)
-		fn := GetFalseNegatives(k, c)
-		prec := GetPrecision(k, c)
-		rec := GetRecall(k, c)
-		f1 := GetF1Score(k, c)
-		fmt.Fprintf(w, "%v\t%v\t%.0f\t%.0f\t%.0f\t%.0f\t%.3f\t%.3f\t%.4f\n", k, t, tp, fp, tn, fn, prec, rec, f1)
-	}
-	w.Flush()
-	buffer.WriteString(fmt.Sprintf("False Positives:  %v\n", c[0].T))
-	buffer.WriteString(fmt.Sprintf("Overall accuracy: %.4f\n", GetAccuracy(c)))
-
-	return buffer.String()
+	classes := make([]CID, len(keys))
+	for i, k := range keys {
+		classes[i] = CID(k)
+	}
+
+	n := len(classes)
+	cells := make([][]int, n+1)
+	for i := range cells {
+		cells[i] = make([]int, n+1)
+	}
+	for i, t := range classes {
+		found := 0
+		for j, p := range classes {
+			cells[i][j] = c[t].P[p]
+			found += cells[i][j]
+		}
+		cells[i][n] = c[t].T - found
+	}
+	for j, p := range classes {
+		cells[n][j] = c[FP].P[p]
+	}
+	return MatrixCells{Classes: classes, Cells: cells}
+}
+
+// RowTotal is the sum of a row, for truth rows the count of truth
+func (m MatrixCells) RowTotal(i int) int {
+	total := 0
+	for _, v := range m.Cells[i] {
+		total += v
+	}
+	return total
+}
+
+// Value of a cell, as a fraction of the row when normalized
+func (m MatrixCells) Value(i, j int, normalize bool) float64 {
+	v := float64(m.Cells[i][j])
+	if normalize {
+		total := m.RowTotal(i)
+		if total == 0 {
+			return 0
+		}
+		return v / float64(total)
+	}
+	return v
+}
+
+// RowName names the rows, where the last is the background
+func (m MatrixCells) RowName(i int, labels map[CID]string) string {
+	if i == len(m.Classes) {
+		return "background"
+	}
+	return LabelName(labels, m.Classes[i])
+}
+
+// ColName names the columns, where the last is the missed truth
+func (m MatrixCells) ColName(j int, labels map[CID]string) string {
+	if j == len(m.Classes) {
+		return "missed"
+	}
+	return LabelName(labels, m.Classes[j])
+}
+
+// WriteConfusionMatrix writes the full matrix as csv, with rows normalized
+// to fractions of the row when normalize is set
+func WriteConfusionMatrix(w io.Writer, c ConfusionMatrix, labels map[CID]string, normalize bool) error {
+	m := GetMatrixCells(c)
+	n := len(m.Classes)
+
+	cw := csv.NewWriter(w)
+	header := make([]string, 0, n+2)
+	header = append(header, `truth\predicted`)
+	for j := 0; j <= n; j++ {
+		header = append(header, m.ColName(j, labels))
+	}
+	cw.Write(header)
+
+	for i := 0; i <= n; i++ {
+		row := make([]string, 0, n+2)
+		row = append(row, m.RowName(i, labels))
+		for j := 0; j <= n; j++ {
+			if normalize {
+				row = append(row, strconv.FormatFloat(m.Value(i, j, true), 'f', 4, 64))
+			} else {
+				row = append(row, strconv.Itoa(m.Cells[i][j]))
+			}
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
 }
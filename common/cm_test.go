@@ -0,0 +1,216 @@
+package common
+
+import (
+	"math"
+	"testing"
+)
+
+var nan = math.NaN()
+
+// equal to within rounding, where NaN equals NaN
+func near(a, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	return math.Abs(a-b) < 1e-9
+}
+
+type metricCase struct {
+	name string
+	got  float64
+	want float64
+}
+
+func checkMetrics(t *testing.T, cases []metricCase) {
+	t.Helper()
+	for _, tt := range cases {
+		if !near(tt.got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+// class 1 has 2 truth, one found as 1 and one as 2, class 3 has 1 truth
+// that is missed and class 2 is only ever predicted, once against the
+// background
+var mixed = ConfusionMatrix{
+	1:  {T: 2, P: map[CID]int{1: 1, 2: 1}},
+	3:  {T: 1, P: map[CID]int{}},
+	FP: {T: 1, P: map[CID]int{2: 1}},
+}
+
+func TestCounts(t *testing.T) {
+	checkMetrics(t, []metricCase{
+		{"tp 1", GetTruePositives(1, mixed), 1},
+		{"tp background", GetTruePositives(FP, mixed), 0},
+		{"fp 1", GetFalsePositives(1, mixed), 0},
+		{"fp 2 misclassified and background", GetFalsePositives(2, mixed), 2},
+		{"fn 1", GetFalseNegatives(1, mixed), 1},
+		{"fn 3 missed", GetFalseNegatives(3, mixed), 1},
+		{"fn background", GetFalseNegatives(FP, mixed), 0},
+		{"tn 1", GetTrueNegatives(1, mixed), 2},
+		{"total with background", GetTotal(mixed), 4},
+	})
+}
+
+func TestEmptyClasses(t *testing.T) {
+	// class 4 is truth that is never predicted, class 5 is predicted and
+	// has a row but no truth
+	c := ConfusionMatrix{
+		4:  {T: 3, P: map[CID]int{}},
+		5:  {T: 0, P: map[CID]int{}},
+		FP: {T: 2, P: map[CID]int{5: 2}},
+	}
+	checkMetrics(t, []metricCase{
+		{"precision never predicted", GetPrecision(4, c), nan},
+		{"recall never predicted", GetRecall(4, c), 0},
+		{"f1 never predicted", GetF1Score(4, c), nan},
+		{"precision no truth", GetPrecision(5, c), 0},
+		{"recall no truth", GetRecall(5, c), nan},
+		{"f1 no truth", GetF1Score(5, c), nan},
+		{"precision absent", GetPrecision(6, c), nan},
+		{"recall absent", GetRecall(6, c), nan},
+		{"accuracy", GetAccuracy(c), 0},
+		{"micro precision", GetMicroPrecision(c), 0},
+		{"micro recall", GetMicroRecall(c), 0},
+		{"micro f1", GetMicroF1(c), 0},
+	})
+}
+
+func TestEmptyMatrix(t *testing.T) {
+	for _, c := range []ConfusionMatrix{{}, {FP: {P: map[CID]int{}}}} {
+		checkMetrics(t, []metricCase{
+			{"accuracy", GetAccuracy(c), nan},
+			{"micro precision", GetMicroPrecision(c), nan},
+			{"micro recall", GetMicroRecall(c), nan},
+			{"micro f1", GetMicroF1(c), nan},
+			{"macro f1 skip", GetMacroF1(c, UndefinedSkip), nan},
+			{"macro f1 zero", GetMacroF1(c, UndefinedZero), nan},
+			{"kappa", GetKappa(c), nan},
+			{"mcc", GetMCC(c), nan},
+		})
+	}
+}
+
+func TestUndefined(t *testing.T) {
+	tests := []struct {
+		name        string
+		u           Undefined
+		resolved    float64
+		macroP      float64
+		macroR      float64
+		macroF1     float64
+		weightedF1  float64
+		balancedAcc float64
+	}{
+		// precision of 1 is 1, of the predicted only 2 is 0 and of 3
+		// undefined, F1 of 1 is 2/3 and of 3 undefined
+		{"na", UndefinedNA, nan, nan, .25, nan, nan, .25},
+		{"zero", UndefinedZero, 0, 1. / 3, .25, 1. / 3, 2. / 3 * 2 / 3, .25},
+		{"skip", UndefinedSkip, nan, .5, .25, 2. / 3, 2. / 3, .25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkMetrics(t, []metricCase{
+				{"resolve nan", tt.u.Resolve(nan), tt.resolved},
+				{"resolve defined", tt.u.Resolve(.5), .5},
+				{"macro precision", GetMacroPrecision(mixed, tt.u), tt.macroP},
+				{"macro recall", GetMacroRecall(mixed, tt.u), tt.macroR},
+				{"macro f1", GetMacroF1(mixed, tt.u), tt.macroF1},
+				{"weighted f1", GetWeightedF1(mixed, tt.u), tt.weightedF1},
+				{"balanced accuracy", GetBalancedAccuracy(mixed, tt.u), tt.balancedAcc},
+				{"mean of none", tt.u.Mean(nil, nil), nan},
+			})
+		})
+	}
+}
+
+func TestParseUndefined(t *testing.T) {
+	for s, want := range map[string]Undefined{"na": UndefinedNA, "zero": UndefinedZero, "skip": UndefinedSkip} {
+		u, err := ParseUndefined(s)
+		if err != nil || u != want {
+			t.Errorf("%s: got %v, %v", s, u, err)
+		}
+	}
+	if _, err := ParseUndefined("none"); err == nil {
+		t.Error("none: expected an error")
+	}
+}
+
+func TestAccuracy(t *testing.T) {
+	tests := []struct {
+		name string
+		c    ConfusionMatrix
+		want float64
+	}{
+		// one correct of two truth, a missed truth and a background FP
+		{"mixed", mixed, .25},
+		{"perfect", ConfusionMatrix{1: {T: 2, P: map[CID]int{1: 2}}, FP: {P: map[CID]int{}}}, 1},
+		// false positives count against accuracy, not only missed truth
+		{"background fp", ConfusionMatrix{1: {T: 1, P: map[CID]int{1: 1}}, FP: {T: 3, P: map[CID]int{1: 3}}}, .25},
+		{"all missed", ConfusionMatrix{1: {T: 4, P: map[CID]int{}}}, 0},
+	}
+	for _, tt := range tests {
+		if got := GetAccuracy(tt.c); !near(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBackgroundNotAveraged(t *testing.T) {
+	// a large FP background row changes precision of the predicted classes
+	// but is not a class of its own in macro averages
+	c := ConfusionMatrix{
+		1:  {T: 1, P: map[CID]int{1: 1}},
+		2:  {T: 1, P: map[CID]int{2: 1}},
+		FP: {T: 100, P: map[CID]int{1: 50, 2: 50}},
+	}
+	if got := c.Classes(); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("classes: got %v, want [1 2]", got)
+	}
+	for _, u := range []Undefined{UndefinedNA, UndefinedZero, UndefinedSkip} {
+		checkMetrics(t, []metricCase{
+			{"macro recall", GetMacroRecall(c, u), 1},
+			{"macro precision", GetMacroPrecision(c, u), 1. / 51},
+		})
+	}
+}
+
+func TestAgreement(t *testing.T) {
+	tests := []struct {
+		name  string
+		c     ConfusionMatrix
+		kappa float64
+		mcc   float64
+	}{
+		{
+			"perfect",
+			ConfusionMatrix{1: {T: 5, P: map[CID]int{1: 5}}, 2: {T: 5, P: map[CID]int{2: 5}}, FP: {P: map[CID]int{}}},
+			1, 1,
+		},
+		{
+			// [[8 2] [1 9]], po .85 and pe .5; the binary MCC (8*9-2*1)/sqrt(10*10*9*11)
+			"two classes",
+			ConfusionMatrix{1: {T: 10, P: map[CID]int{1: 8, 2: 2}}, 2: {T: 10, P: map[CID]int{1: 1, 2: 9}}, FP: {P: map[CID]int{}}},
+			.7, 70 / math.Sqrt(9900),
+		},
+		{
+			// truth 1 against background; [[3 1] [2 0]] with the missed column
+			"with background",
+			ConfusionMatrix{1: {T: 4, P: map[CID]int{1: 3}}, FP: {T: 2, P: map[CID]int{1: 2}}},
+			(.5 - 22./36) / (1 - 22./36), (3*0 - 2*1) / math.Sqrt(4*2*5*1),
+		},
+		{
+			// every prediction swapped
+			"inverse",
+			ConfusionMatrix{1: {T: 5, P: map[CID]int{2: 5}}, 2: {T: 5, P: map[CID]int{1: 5}}, FP: {P: map[CID]int{}}},
+			-1, -1,
+		},
+	}
+	for _, tt := range tests {
+		checkMetrics(t, []metricCase{
+			{tt.name + " kappa", GetKappa(tt.c), tt.kappa},
+			{tt.name + " mcc", GetMCC(tt.c), tt.mcc},
+		})
+	}
+}
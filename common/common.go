@@ -2,12 +2,14 @@ package common
 
 import (
 	"bytes"
+	"encoding/base64"
+	xdraw "golang.org/x/image/draw"
 	_ "golang.org/x/image/tiff"
 	"image"
 	"image/jpeg"
+	"math"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 )
 
@@ -15,6 +17,7 @@ type TID int
 type DID int
 type CID int
 
+// a chip of an image, at X,Y pixels in the image
 type Chip struct {
 	X  int
 	Y  int
@@ -25,6 +28,8 @@ type Truth struct {
 	Id     TID
 	Bounds image.Rectangle
 	Class  CID
+	// don't care, detections of it are neither rewarded nor penalized
+	Ignore bool
 }
 
 type Detect struct {
@@ -41,17 +46,21 @@ type Match struct {
 	IoU float32
 }
 
-// (xmin,ymin,xmax,ymax)
-func SplitToRect(a []string) image.Rectangle {
-	mx, _ := strconv.Atoi(a[0])
-	my, _ := strconv.Atoi(a[1])
-	Mx, _ := strconv.Atoi(a[2])
-	My, _ := strconv.Atoi(a[3])
+// Area of a rectangle in pixels
+func Area(r image.Rectangle) int {
+	z := r.Size()
+	return z.X * z.Y
+}
 
-	return image.Rectangle{
-		Min: image.Point{X: mx, Y: my},
-		Max: image.Point{X: Mx, Y: My},
+// IoU computes the intersection over union of two rectangles,
+// zero when they do not overlap
+func IoU(a, b image.Rectangle) float32 {
+	i := a.Intersect(b)
+	if i.Empty() {
+		return 0
 	}
+	ia := Area(i)
+	return float32(ia) / float32(Area(a)+Area(b)-ia)
 }
 
 type YoloLabel struct {
@@ -92,6 +101,23 @@ func LoadJpeg(imagefile string) (image.Image, error) {
 	return jpeg.Decode(buf)
 }
 
+// JPEGDataURI encodes im as a jpeg data uri of quality, scaled down to at
+// most maxSize pixels a side when maxSize is positive
+func JPEGDataURI(im image.Image, maxSize, quality int) (string, error) {
+	sz := im.Bounds().Size()
+	if maxSize > 0 && (sz.X > maxSize || sz.Y > maxSize) {
+		s := float64(maxSize) / math.Max(float64(sz.X), float64(sz.Y))
+		thumb := image.NewRGBA(image.Rect(0, 0, ceilScale(sz.X, s), ceilScale(sz.Y, s)))
+		xdraw.CatmullRom.Scale(thumb, thumb.Bounds(), im, im.Bounds(), xdraw.Src, nil)
+		im = thumb
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, im, &jpeg.Options{Quality: quality}); err != nil {
+		return "", err
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
 func ResizeRect(rect image.Rectangle, ratio float32) image.Rectangle {
 	if ratio == 1.0 {
 		return rect
@@ -0,0 +1,171 @@
+package common
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/fogleman/gg"
+	xdraw "golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// the centers of detections smoothed by a gaussian kernel over a grid of
+// cells, in detections per cell
+type Density struct {
+	Cell   int
+	Cols   int
+	Rows   int
+	Values []float64
+}
+
+// NewDensity grids the detections of an image of size into cells of cell
+// pixels, spreading each over a gaussian of sigma pixels, or counting them
+// in their cell when sigma is 0. Detections near the edge lose the part of
+// the kernel outside the image.
+func NewDensity(size image.Point, cell int, sigma float64, detects []Detect) Density {
+	d := Density{
+		Cell: cell,
+		Cols: (size.X + cell - 1) / cell,
+		Rows: (size.Y + cell - 1) / cell,
+	}
+	d.Values = make([]float64, d.Cols*d.Rows)
+	s := sigma / float64(cell)
+	r := int(math.Ceil(3 * s))
+	for _, det := range detects {
+		c := det.Bounds.Min.Add(det.Bounds.Max)
+		// in cells, with cell centers on whole numbers
+		fx, fy := float64(c.X)/2/float64(cell)-.5, float64(c.Y)/2/float64(cell)-.5
+		if s <= 0 {
+			d.add(int(math.Round(fx)), int(math.Round(fy)), 1)
+			continue
+		}
+
+		x0, y0 := int(math.Round(fx))-r, int(math.Round(fy))-r
+		weights := make([]float64, (2*r+1)*(2*r+1))
+		sum := 0.0
+		for j := 0; j <= 2*r; j++ {
+			for i := 0; i <= 2*r; i++ {
+				dx, dy := float64(x0+i)-fx, float64(y0+j)-fy
+				w := math.Exp(-(dx*dx + dy*dy) / (2 * s * s))
+				weights[j*(2*r+1)+i] = w
+				sum += w
+			}
+		}
+		for j := 0; j <= 2*r; j++ {
+			for i := 0; i <= 2*r; i++ {
+				d.add(x0+i, y0+j, weights[j*(2*r+1)+i]/sum)
+			}
+		}
+	}
+	return d
+}
+
+func (d Density) add(col, row int, v float64) {
+	if col >= 0 && col < d.Cols && row >= 0 && row < d.Rows {
+		d.Values[row*d.Cols+col] += v
+	}
+}
+
+// Max density of a cell
+func (d Density) Max() float64 {
+	max := 0.0
+	for _, v := range d.Values {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// WriteCSV writes a row of x,y,density for each cell, with x and y the
+// pixel at its center
+func (d Density) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"x", "y", "density"}); err != nil {
+		return err
+	}
+	for row := 0; row < d.Rows; row++ {
+		for col := 0; col < d.Cols; col++ {
+			x, y := col*d.Cell+d.Cell/2, row*d.Cell+d.Cell/2
+			if err := cw.Write([]string{fmt.Sprint(x), fmt.Sprint(y), fmt.Sprint(d.Values[row*d.Cols+col])}); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Overlay colors each cell by HeatColor relative to max, more opaque the
+// denser up to opacity, smoothly scaled up to cover an image of size
+func (d Density) Overlay(size image.Point, max, opacity float64) image.Image {
+	grid := image.NewRGBA(image.Rect(0, 0, d.Cols, d.Rows))
+	for row := 0; row < d.Rows; row++ {
+		for col := 0; col < d.Cols; col++ {
+			t := 0.0
+			if max > 0 {
+				t = math.Min(d.Values[row*d.Cols+col]/max, 1)
+			}
+			grid.Set(col, row, withAlpha(HeatColor(t), opacity*math.Sqrt(t)))
+		}
+	}
+	ret := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	xdraw.BiLinear.Scale(ret, image.Rect(0, 0, d.Cols*d.Cell, d.Rows*d.Cell), grid, grid.Bounds(), xdraw.Src, nil)
+	return ret
+}
+
+// ramp of HeatColor, from cold to hot
+var heatRamp = []color.RGBA{
+	{R: 0, G: 0, B: 255, A: 255},
+	{R: 0, G: 255, B: 255, A: 255},
+	{R: 0, G: 255, B: 0, A: 255},
+	{R: 255, G: 255, B: 0, A: 255},
+	{R: 255, G: 0, B: 0, A: 255},
+}
+
+// HeatColor returns the color of t between 0 and 1 on a ramp of blue, cyan,
+// green, yellow and red
+func HeatColor(t float64) color.RGBA {
+	t = math.Max(0, math.Min(t, 1)) * float64(len(heatRamp)-1)
+	i := int(t)
+	if i == len(heatRamp)-1 {
+		return heatRamp[i]
+	}
+	f := t - float64(i)
+	a, b := heatRamp[i], heatRamp[i+1]
+	mix := func(x, y uint8) uint8 {
+		return uint8(float64(x) + (float64(y)-float64(x))*f)
+	}
+	return color.RGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: 255}
+}
+
+func withAlpha(c color.RGBA, alpha float64) color.RGBA {
+	return color.RGBA{
+		R: uint8(float64(c.R) * alpha),
+		G: uint8(float64(c.G) * alpha),
+		B: uint8(float64(c.B) * alpha),
+		A: uint8(255 * alpha),
+	}
+}
+
+// DrawColorBar draws the ramp of HeatColor from 0 to max in the bottom left
+// corner, in the current font
+func DrawColorBar(dc *gg.Context, max float64, label string) {
+	lh, pad := legendLayout(dc)
+	w, h := 10*lh, lh*.6
+	x, y := pad, float64(dc.Height())-pad-3*lh-h
+	dc.SetRGBA(1, 1, 1, .85)
+	dc.DrawRectangle(x, y, w+2*pad, 3*lh+h)
+	dc.Fill()
+	for i := 0; i < int(w); i++ {
+		dc.SetColor(HeatColor(float64(i) / w))
+		dc.DrawRectangle(x+pad+float64(i), y+pad, 1, h)
+		dc.Fill()
+	}
+	dc.SetColor(color.Black)
+	dc.DrawStringAnchored("0", x+pad, y+pad+h+lh/2, 0, .35)
+	dc.DrawStringAnchored(fmt.Sprintf("%.3g", max), x+pad+w, y+pad+h+lh/2, 1, .35)
+	dc.DrawStringAnchored(label, x+pad+w/2, y+pad+h+lh*1.5, .5, .35)
+}
@@ -1,21 +1,64 @@
 package common
 
 import (
+	"fmt"
+	"io"
+	"os"
 	"strconv"
 )
 
-func ReadDetects(predictions [][]string) []Detect {
+// ReadDetects reads predictions of `xmin ymin xmax ymax class confidence'
+// lines, delimited by spaces, tabs or commas, as written by detect. name is
+// the file r is read from, for errors.
+func ReadDetects(r io.Reader, name string, mode ParseMode) ([]Detect, error) {
 	detects := make([]Detect, 0)
-	for i, splits := range predictions {
-		class, _ := strconv.Atoi(splits[4])
-		score, _ := strconv.ParseFloat(splits[5], 32)
-		detects = append(detects, Detect{
-			Id: DID(i),
-			Bounds: SplitToRect(splits),
-			Class:      CID(class),
-			Chip:       nil,
-			Confidence: float32(score),
-		})
+	err := ReadLines(r, name, mode, func(line string) error {
+		d, err := ParseDetect(Fields(line))
+		if err != nil {
+			return err
+		}
+		d.Id = DID(len(detects))
+		detects = append(detects, d)
+		return nil
+	})
+	return detects, err
+}
+
+// ParseDetect parses the fields of a predictions line
+func ParseDetect(fields []string) (Detect, error) {
+	if len(fields) != 6 {
+		return Detect{}, fmt.Errorf("expected xmin ymin xmax ymax class confidence, got %d fields", len(fields))
+	}
+	bounds, err := ParseRect(fields)
+	if err != nil {
+		return Detect{}, err
+	}
+	class, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return Detect{}, err
+	}
+	score, err := strconv.ParseFloat(fields[5], 32)
+	if err != nil {
+		return Detect{}, err
+	}
+	return Detect{
+		Bounds:     bounds,
+		Class:      CID(class),
+		Chip:       nil,
+		Confidence: float32(score),
+	}, nil
+}
+
+// ReadPredictions reads a predictions file as written by detect, or - for stdin
+func ReadPredictions(predictionsFile string, mode ParseMode) ([]Detect, error) {
+	f := os.Stdin
+	if predictionsFile != "-" {
+		var err error
+		f, err = os.Open(predictionsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
 	}
-	return detects
+	return ReadDetects(f, predictionsFile, mode)
 }
@@ -0,0 +1,281 @@
+package common
+
+import (
+	"fmt"
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/colornames"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"image"
+	"image/color"
+	"math"
+)
+
+// where box captions are drawn
+type CaptionMode int
+
+const (
+	// above the box, or wherever else near it does not overlap another
+	// caption, skipping captions with no free spot
+	CaptionAuto CaptionMode = iota
+	CaptionAbove
+	CaptionInside
+	CaptionNone
+)
+
+// ParseCaptionMode parses auto, above, inside, or none
+func ParseCaptionMode(s string) (CaptionMode, error) {
+	switch s {
+	case "auto":
+		return CaptionAuto, nil
+	case "above":
+		return CaptionAbove, nil
+	case "inside":
+		return CaptionInside, nil
+	case "none":
+		return CaptionNone, nil
+	}
+	return CaptionAuto, fmt.Errorf("caption placement must be auto, above, inside, or none, not %q", s)
+}
+
+// a box to draw with an optional caption
+type Box struct {
+	Bounds  image.Rectangle
+	Color   color.Color
+	Caption string
+	// dash lengths of the outline, solid when empty
+	Dash []float64
+	// data attributes of the box in vector output, eg. its class
+	Attrs map[string]string
+}
+
+// boxes that are drawn and toggled together, eg. the detections of a class
+type BoxLayer struct {
+	Name  string
+	Color color.Color
+	Boxes []Box
+}
+
+// Legend has an entry for each layer with its count of boxes
+func Legend(layers []BoxLayer) []LegendEntry {
+	ret := make([]LegendEntry, len(layers))
+	for i, l := range layers {
+		ret[i] = LegendEntry{Color: l.Color, Text: fmt.Sprintf("%s (%d)", l.Name, len(l.Boxes))}
+	}
+	return ret
+}
+
+// ScaleBoxes returns a copy of boxes with bounds scaled by s
+func ScaleBoxes(boxes []Box, s float64) []Box {
+	ret := make([]Box, len(boxes))
+	for i, b := range boxes {
+		r := b.Bounds
+		b.Bounds = image.Rect(int(float64(r.Min.X)*s), int(float64(r.Min.Y)*s), int(math.Ceil(float64(r.Max.X)*s)), int(math.Ceil(float64(r.Max.Y)*s)))
+		ret[i] = b
+	}
+	return ret
+}
+
+// an entry of a legend
+type LegendEntry struct {
+	Color color.Color
+	Text  string
+}
+
+var goregularFont *truetype.Font
+
+// FontFace returns the Go font at a size in points
+func FontFace(points float64) font.Face {
+	if goregularFont == nil {
+		goregularFont, _ = truetype.Parse(goregular.TTF)
+	}
+	return truetype.NewFace(goregularFont, &truetype.Options{Size: points})
+}
+
+// ClassColor returns a color for a class that is the same on every render,
+// with hues of consecutive classes spread apart by the golden ratio
+func ClassColor(class CID) color.Color {
+	h := math.Mod(float64(class)*0.618033988749895, 1)
+	return hsv(h, .85, .95)
+}
+
+func hsv(h, s, v float64) color.Color {
+	i := math.Floor(h * 6)
+	f := h*6 - i
+	p, q, t := v*(1-s), v*(1-f*s), v*(1-(1-f)*s)
+	var r, g, b float64
+	switch int(i) % 6 {
+	case 0:
+		r, g, b = v, t, p
+	case 1:
+		r, g, b = q, v, p
+	case 2:
+		r, g, b = p, v, t
+	case 3:
+		r, g, b = p, q, v
+	case 4:
+		r, g, b = t, p, v
+	default:
+		r, g, b = v, p, q
+	}
+	return color.RGBA{R: uint8(r * 255), G: uint8(g * 255), B: uint8(b * 255), A: 255}
+}
+
+// Color of the outcome in review, green, red, orange, or blue when missed
+func (o Outcome) Color() color.Color {
+	switch o {
+	case OutcomeTP:
+		return colornames.Limegreen
+	case OutcomeFP:
+		return colornames.Red
+	case OutcomeMisclassified:
+		return colornames.Orange
+	}
+	return colornames.Deepskyblue
+}
+
+// TextColor returns black or white, whichever reads better on c
+func TextColor(c color.Color) color.Color {
+	r, g, b, _ := c.RGBA()
+	if 0.299*float64(r)+0.587*float64(g)+0.114*float64(b) > 0x8000 {
+		return color.Black
+	}
+	return color.White
+}
+
+// DrawBoxes strokes each box in its color, and then captions them in order,
+// so that with CaptionAuto earlier boxes get the better spots. CaptionAuto
+// also keeps captions out of reserved, eg. the LegendBounds.
+func DrawBoxes(dc *gg.Context, boxes []Box, lineWidth float64, mode CaptionMode, reserved ...image.Rectangle) {
+	dc.SetLineWidth(lineWidth)
+	for _, b := range boxes {
+		r := b.Bounds
+		dc.SetColor(b.Color)
+		dc.SetDash(b.Dash...)
+		dc.DrawRectangle(float64(r.Min.X), float64(r.Min.Y), float64(r.Dx()), float64(r.Dy()))
+		dc.Stroke()
+	}
+	dc.SetDash()
+
+	for i, at := range PlaceCaptions(dc, boxes, mode, reserved...) {
+		if at.Empty() {
+			continue
+		}
+		b := boxes[i]
+		dc.SetColor(b.Color)
+		dc.DrawRectangle(float64(at.Min.X), float64(at.Min.Y), float64(at.Dx()), float64(at.Dy()))
+		dc.Fill()
+		dc.SetColor(TextColor(b.Color))
+		dc.DrawStringAnchored(b.Caption, float64(at.Min.X)+2, float64(at.Min.Y)+float64(at.Dy())/2, 0, .35)
+	}
+}
+
+// PlaceCaptions returns where DrawBoxes captions each box in the font of dc,
+// or an empty rectangle for boxes without a caption or a spot for it
+func PlaceCaptions(dc *gg.Context, boxes []Box, mode CaptionMode, reserved ...image.Rectangle) []image.Rectangle {
+	ret := make([]image.Rectangle, len(boxes))
+	if mode == CaptionNone {
+		return ret
+	}
+
+	extent := image.Rect(0, 0, dc.Width(), dc.Height())
+	// placed captions, to test for overlaps on dense scenes
+	placed := NewGridIndex(reserved, captionCell)
+	for i, b := range boxes {
+		if b.Caption == "" {
+			continue
+		}
+		w, h := dc.MeasureString(b.Caption)
+		size := image.Pt(int(math.Ceil(w))+4, int(math.Ceil(h))+4)
+		r := b.Bounds
+
+		var at image.Rectangle
+		found := false
+		switch mode {
+		case CaptionAbove:
+			at, found = clampRect(image.Rectangle{Min: image.Pt(r.Min.X, r.Min.Y-size.Y), Max: image.Pt(r.Min.X+size.X, r.Min.Y)}, extent), true
+		case CaptionInside:
+			at, found = clampRect(image.Rectangle{Min: r.Min, Max: r.Min.Add(size)}, extent), true
+		default:
+			candidates := []image.Point{
+				{X: r.Min.X, Y: r.Min.Y - size.Y},
+				{X: r.Min.X, Y: r.Max.Y},
+				{X: r.Max.X - size.X, Y: r.Min.Y - size.Y},
+				{X: r.Max.X - size.X, Y: r.Max.Y},
+				r.Min,
+			}
+			for _, p := range candidates {
+				c := image.Rectangle{Min: p, Max: p.Add(size)}
+				if c.In(extent) && !placed.Overlaps(c) {
+					at, found = c, true
+					break
+				}
+			}
+		}
+		if found {
+			placed.Add(at)
+			ret[i] = at
+		}
+	}
+	return ret
+}
+
+// cell pixels of the index of placed captions
+const captionCell = 64
+
+// DrawLegend draws a panel of color swatches and text in the top left corner
+func DrawLegend(dc *gg.Context, entries []LegendEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	lh, pad := legendLayout(dc)
+	r := LegendBounds(dc, entries)
+	dc.SetRGBA(1, 1, 1, .85)
+	dc.DrawRectangle(float64(r.Min.X), float64(r.Min.Y), float64(r.Dx()), float64(r.Dy()))
+	dc.Fill()
+	for i, e := range entries {
+		y := pad + pad/2 + float64(i)*lh
+		dc.SetColor(e.Color)
+		dc.DrawRectangle(2*pad, y+lh*.2, lh*.6, lh*.6)
+		dc.Fill()
+		dc.SetColor(color.Black)
+		dc.DrawStringAnchored(e.Text, 2*pad+lh, y+lh/2, 0, .35)
+	}
+}
+
+// LegendBounds returns where DrawLegend draws the panel
+func LegendBounds(dc *gg.Context, entries []LegendEntry) image.Rectangle {
+	if len(entries) == 0 {
+		return image.Rectangle{}
+	}
+	lh, pad := legendLayout(dc)
+	w := 0.0
+	for _, e := range entries {
+		if tw, _ := dc.MeasureString(e.Text); tw > w {
+			w = tw
+		}
+	}
+	return image.Rect(int(pad), int(pad), int(math.Ceil(w+lh+3*pad)), int(math.Ceil(float64(len(entries))*lh+2*pad)))
+}
+
+// line height and padding of a legend in the current font
+func legendLayout(dc *gg.Context) (float64, float64) {
+	lh := dc.FontHeight() * 1.6
+	return lh, lh / 2
+}
+
+func clampRect(r, extent image.Rectangle) image.Rectangle {
+	d := image.Point{}
+	if r.Min.X < extent.Min.X {
+		d.X = extent.Min.X - r.Min.X
+	} else if r.Max.X > extent.Max.X {
+		d.X = extent.Max.X - r.Max.X
+	}
+	if r.Min.Y < extent.Min.Y {
+		d.Y = extent.Min.Y - r.Min.Y
+	} else if r.Max.Y > extent.Max.Y {
+		d.Y = extent.Max.Y - r.Max.Y
+	}
+	return r.Add(d)
+}
@@ -0,0 +1,260 @@
+//
+// error breakdown modeled after TIDE, https://github.com/dbolya/tide
+//
+
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"text/tabwriter"
+)
+
+type ErrorType int
+
+const (
+	// overlaps truth of another class at the foreground iou
+	ClsError ErrorType = iota
+	// overlaps truth of the same class between the background and foreground iou
+	LocError
+	// overlaps truth of another class between the background and foreground iou
+	BothError
+	// overlaps truth of the same class that was already matched
+	DupeError
+	// overlaps no truth above the background iou
+	BkgError
+	// truth that was never detected, or only by a background detection
+	MissError
+)
+
+var ErrorTypes = []ErrorType{ClsError, LocError, BothError, DupeError, BkgError, MissError}
+
+func (e ErrorType) String() string {
+	switch e {
+	case ClsError:
+		return "Classification"
+	case LocError:
+		return "Localization"
+	case BothError:
+		return "Cls+Loc"
+	case DupeError:
+		return "Duplicate"
+	case BkgError:
+		return "Background"
+	case MissError:
+		return "Missed"
+	}
+	return fmt.Sprintf("ErrorType(%d)", int(e))
+}
+
+// a false positive detection, with the truth it was nearest
+type DetectError struct {
+	Type  ErrorType
+	Scene string
+	D     Detect
+	T     *Truth
+	IoU   float32
+
+	// position in the confidence ordered detections of the scene
+	i int
+}
+
+// truth that was never detected
+type MissedTruth struct {
+	Scene string
+	T     Truth
+}
+
+type ErrorAnalysis struct {
+	Errors  []DetectError
+	Missed  []MissedTruth
+	Counts  map[ErrorType]int
+	MAP     float64
+	DeltaAP map[ErrorType]float64
+}
+
+// the matching of one scene, kept to apply the oracles
+type sceneErrors struct {
+	scene  Scene
+	sorted []Detect
+	used   map[TID]bool
+	errors []DetectError
+	missed map[TID]bool
+}
+
+// AnalyzeErrors classifies every false positive detection and missed truth
+// by the kind of error, and computes how much mAP would be gained by fixing
+// each kind of error with an oracle.  A detection is a true positive at the
+// foreground iou, fgIou, and may be explained by truth down to the background
+// iou, bgIou.
+func AnalyzeErrors(scenes []Scene, fgIou, bgIou float32) ErrorAnalysis {
+	ret := ErrorAnalysis{
+		Counts:  make(map[ErrorType]int),
+		DeltaAP: make(map[ErrorType]float64),
+	}
+
+	analyzed := make([]sceneErrors, len(scenes))
+	results := make([]SceneResult, len(scenes))
+	for i, s := range scenes {
+		analyzed[i] = analyzeScene(s, fgIou, bgIou)
+		results[i] = ScoreScene(s.Name, s.Truth, s.Detects, fgIou)
+
+		for _, e := range analyzed[i].errors {
+			ret.Errors = append(ret.Errors, e)
+			ret.Counts[e.Type]++
+		}
+		for _, t := range s.Truth {
+			if analyzed[i].missed[t.Id] {
+				ret.Missed = append(ret.Missed, MissedTruth{Scene: s.Name, T: t})
+			}
+		}
+	}
+	ret.Counts[MissError] = len(ret.Missed)
+
+	ret.MAP = MeanAP(GetSceneCurves(results))
+	for _, et := range ErrorTypes {
+		fixed := make([]SceneResult, len(analyzed))
+		for i, a := range analyzed {
+			ft, fd := a.fix(et)
+			fixed[i] = ScoreScene(a.scene.Name, ft, fd, fgIou)
+		}
+		ret.DeltaAP[et] = MeanAP(GetSceneCurves(fixed)) - ret.MAP
+	}
+
+	return ret
+}
+
+func analyzeScene(s Scene, fgIou, bgIou float32) sceneErrors {
+	truth := s.Truth
+	sorted, matches := MatchByClass(truth, s.Detects, fgIou)
+	index := NewTruthIndex(truth)
+
+	ret := sceneErrors{
+		scene:  s,
+		sorted: sorted,
+		used:   make(map[TID]bool),
+		missed: make(map[TID]bool),
+	}
+	for _, m := range matches {
+		if m != nil {
+			ret.used[m.Id] = true
+		}
+	}
+
+	// truth that a cls or loc error would have found
+	explained := make(map[TID]bool)
+	for i, d := range sorted {
+		if matches[i] != nil {
+			continue
+		}
+
+		var same, other *Truth
+		var sameIou, otherIou float32
+		for _, j := range index.Query(d.Bounds) {
+			t := &truth[j]
+			iou := IoU(t.Bounds, d.Bounds)
+			if t.Class == d.Class {
+				if iou > sameIou {
+					same, sameIou = t, iou
+				}
+			} else if iou > otherIou {
+				other, otherIou = t, iou
+			}
+		}
+
+		e := DetectError{Scene: s.Name, D: d, i: i}
+		switch {
+		case sameIou >= fgIou:
+			e.Type, e.T, e.IoU = DupeError, same, sameIou
+		case otherIou >= fgIou:
+			e.Type, e.T, e.IoU = ClsError, other, otherIou
+			explained[other.Id] = true
+		case sameIou >= bgIou:
+			e.Type, e.T, e.IoU = LocError, same, sameIou
+			explained[same.Id] = true
+		case otherIou >= bgIou:
+			e.Type, e.T, e.IoU = BothError, other, otherIou
+		default:
+			e.Type = BkgError
+		}
+		ret.errors = append(ret.errors, e)
+	}
+
+	for _, t := range truth {
+		if !ret.used[t.Id] && !explained[t.Id] {
+			ret.missed[t.Id] = true
+		}
+	}
+	return ret
+}
+
+// applies the oracle for an error type, returning the corrected truth and detections
+func (a sceneErrors) fix(et ErrorType) ([]Truth, []Detect) {
+	truth, detects := a.scene.Truth, a.sorted
+	if et == MissError {
+		ft := make([]Truth, 0, len(truth))
+		for _, t := range truth {
+			if !a.missed[t.Id] {
+				ft = append(ft, t)
+			}
+		}
+		return ft, detects
+	}
+
+	fixes := make(map[int]DetectError)
+	for _, e := range a.errors {
+		if e.Type == et {
+			fixes[e.i] = e
+		}
+	}
+
+	found := make(map[TID]bool, len(a.used))
+	for k, v := range a.used {
+		found[k] = v
+	}
+
+	fd := make([]Detect, 0, len(detects))
+	for i, d := range detects {
+		e, ok := fixes[i]
+		if !ok {
+			fd = append(fd, d)
+			continue
+		}
+		switch et {
+		case ClsError:
+			// correct the class, unless the truth is already found
+			if !found[e.T.Id] {
+				d.Class = e.T.Class
+				found[e.T.Id] = true
+				fd = append(fd, d)
+			}
+		case LocError:
+			// correct the box, unless the truth is already found
+			if !found[e.T.Id] {
+				d.Bounds = e.T.Bounds
+				found[e.T.Id] = true
+				fd = append(fd, d)
+			}
+		}
+		// everything else is suppressed
+	}
+	return truth, fd
+}
+
+// GetErrorSummary returns a table of the count of each error type
+// and the mAP gained when it is fixed
+func GetErrorSummary(a ErrorAnalysis) string {
+	var buffer bytes.Buffer
+	w := new(tabwriter.Writer)
+	w.Init(&buffer, 0, 8, 0, '\t', 0)
+
+	fmt.Fprintln(w, "Error\tCount\tdAP")
+	fmt.Fprintln(w, "-----\t-----\t---")
+	for _, et := range ErrorTypes {
+		fmt.Fprintf(w, "%v\t%v\t%.4f\n", et, a.Counts[et], a.DeltaAP[et])
+	}
+	w.Flush()
+	buffer.WriteString(fmt.Sprintf("mAP: %.4f\n", a.MAP))
+
+	return buffer.String()
+}
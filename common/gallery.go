@@ -0,0 +1,133 @@
+package common
+
+import (
+	"github.com/fogleman/gg"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"image"
+	"image/color"
+	"math"
+)
+
+// the layout of a contact sheet, in pixels
+type Sheet struct {
+	// padding of the crop around each box
+	Pad int
+	// the size of the square each crop is scaled to fit
+	Cell int
+	Cols int
+}
+
+const sheetGap = 6
+
+// ContactSheet crops each box, padded, out of im and lays the crops out row
+// by row under a title, scaled to fit a cell, with the box outlined in its
+// color and its caption underneath
+func (sh Sheet) ContactSheet(im image.Image, boxes []Box, title string, face font.Face) image.Image {
+	return sh.layout(len(boxes), title, face, func(dc *gg.Context, i int, cell image.Rectangle) string {
+		b := boxes[i]
+		crop := b.Bounds.Inset(-sh.Pad).Intersect(im.Bounds())
+		if crop.Empty() {
+			return ""
+		}
+		at, s := fitCell(crop, cell)
+		xdraw.CatmullRom.Scale(dc.Image().(*image.RGBA), at, im, crop, xdraw.Src, nil)
+
+		r := b.Bounds.Sub(crop.Min)
+		dc.SetColor(b.Color)
+		dc.SetDash(b.Dash...)
+		dc.SetLineWidth(1.5)
+		dc.DrawRectangle(float64(at.Min.X)+float64(r.Min.X)*s, float64(at.Min.Y)+float64(r.Min.Y)*s, float64(r.Dx())*s, float64(r.Dy())*s)
+		dc.Stroke()
+		dc.SetDash()
+		return b.Caption
+	})
+}
+
+// Mosaic lays whole images out row by row under a title, each scaled to fit
+// a cell with its caption underneath
+func (sh Sheet) Mosaic(ims []image.Image, captions []string, title string, face font.Face) image.Image {
+	return sh.layout(len(ims), title, face, func(dc *gg.Context, i int, cell image.Rectangle) string {
+		b := ims[i].Bounds()
+		if !b.Empty() {
+			at, _ := fitCell(b, cell)
+			xdraw.CatmullRom.Scale(dc.Image().(*image.RGBA), at, ims[i], b, xdraw.Src, nil)
+		}
+		if i < len(captions) {
+			return captions[i]
+		}
+		return ""
+	})
+}
+
+// lays n cells out row by row under a title, calling draw for each to fill
+// its cell and return its caption, which goes underneath
+func (sh Sheet) layout(n int, title string, face font.Face, draw func(dc *gg.Context, i int, cell image.Rectangle) string) image.Image {
+	cols := sh.Cols
+	if cols > n {
+		cols = n
+	}
+	if cols < 1 {
+		cols = 1
+	}
+	rows := (n + cols - 1) / cols
+	fh := float64(face.Metrics().Height) / 64
+	titleH := int(math.Ceil(fh * 2))
+	captionH := int(math.Ceil(fh)) + 4
+	pitch := image.Pt(sh.Cell+sheetGap, sh.Cell+captionH+sheetGap)
+
+	dc := gg.NewContext(sheetGap+cols*pitch.X, titleH+sheetGap+rows*pitch.Y)
+	dc.SetRGB(.13, .13, .13)
+	dc.Clear()
+	dc.SetFontFace(face)
+	dc.SetColor(color.White)
+	dc.DrawStringAnchored(title, sheetGap, float64(titleH)/2, 0, .35)
+
+	for i := 0; i < n; i++ {
+		cell := image.Rect(0, 0, sh.Cell, sh.Cell).Add(image.Pt(sheetGap+i%cols*pitch.X, titleH+sheetGap+i/cols*pitch.Y))
+		if caption := draw(dc, i, cell); caption != "" {
+			dc.SetColor(color.White)
+			dc.DrawStringAnchored(fitText(dc, caption, float64(sh.Cell)), float64(cell.Min.X+cell.Dx()/2), float64(cell.Max.Y)+float64(captionH)/2, .5, .35)
+		}
+	}
+	return dc.Image()
+}
+
+// where r scaled to fit centered in cell goes, and the scale
+func fitCell(r, cell image.Rectangle) (image.Rectangle, float64) {
+	s := math.Min(float64(cell.Dx())/float64(r.Dx()), float64(cell.Dy())/float64(r.Dy()))
+	size := image.Pt(int(float64(r.Dx())*s), int(float64(r.Dy())*s))
+	return image.Rectangle{Min: cell.Min.Add(cell.Size().Sub(size).Div(2)), Max: cell.Min.Add(cell.Size().Add(size).Div(2))}, s
+}
+
+// Pages splits n items into pages of at most per, or one page when per is
+// 0, as the start and end of each
+func Pages(n, per int) [][2]int {
+	if per <= 0 || per > n {
+		per = n
+	}
+	ret := make([][2]int, 0)
+	for start := 0; start < n; start += per {
+		end := start + per
+		if end > n {
+			end = n
+		}
+		ret = append(ret, [2]int{start, end})
+	}
+	return ret
+}
+
+// the text, shortened with an ellipsis to fit within width
+func fitText(dc *gg.Context, text string, width float64) string {
+	if w, _ := dc.MeasureString(text); w <= width {
+		return text
+	}
+	runes := []rune(text)
+	for n := len(runes) - 1; n > 0; n-- {
+		t := string(runes[:n]) + "…"
+		if w, _ := dc.MeasureString(t); w <= width {
+			return t
+		}
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+type FeatureCollection struct {
+	Features []Feature
+}
+
+type Feature struct {
+	Properties struct {
+		Id     int    `json:"feature_id"`
+		Bounds string `json:"bounds_imcoords"`
+		Class  int    `json:"type_id"`
+		Image  string `json:"image_id"`
+		// optional, truth that detections should not be penalized for
+		Difficult Flag `json:"difficult,omitempty"`
+	}
+}
+
+// a boolean property that may be encoded as true/false or 1/0
+type Flag bool
+
+func (f *Flag) UnmarshalJSON(b []byte) error {
+	switch string(b) {
+	case "true", "1":
+		*f = true
+	case "false", "0", "null":
+		*f = false
+	default:
+		return fmt.Errorf("invalid flag %s", b)
+	}
+	return nil
+}
+
+// ReadFeatures reads an xview ground-truth geojson
+func ReadFeatures(geojsonFile string) (FeatureCollection, error) {
+	var ref FeatureCollection
+	tbytes, err := ioutil.ReadFile(geojsonFile)
+	if err != nil {
+		return ref, err
+	}
+	err = json.Unmarshal(tbytes, &ref)
+	return ref, err
+}
+
+// ParseBounds parses bounds_imcoords of `xmin,ymin,xmax,ymax' pixels
+func ParseBounds(bounds string) (image.Rectangle, error) {
+	splits := strings.Split(bounds, ",")
+	if len(splits) != 4 {
+		return image.Rectangle{}, fmt.Errorf("bounds %q: expected xmin,ymin,xmax,ymax", bounds)
+	}
+	r, err := ParseRect(splits)
+	if err != nil {
+		return r, fmt.Errorf("bounds %q: %v", bounds, err)
+	}
+	return r, nil
+}
+
+// Truth converts the feature to a ground-truth box
+func (f Feature) Truth() (Truth, error) {
+	b, err := ParseBounds(f.Properties.Bounds)
+	if err != nil {
+		return Truth{}, err
+	}
+	return Truth{
+		Id:     TID(f.Properties.Id),
+		Bounds: b,
+		Class:  CID(f.Properties.Class),
+		Ignore: bool(f.Properties.Difficult),
+	}, nil
+}
+
+// the truth of the i'th feature of geojsonFile, or false when it is
+// malformed and skipped in Lenient mode
+func featureTruth(geojsonFile string, i int, f Feature, mode ParseMode) (Truth, bool, error) {
+	t, err := f.Truth()
+	if err == nil {
+		return t, true, nil
+	}
+	err = fmt.Errorf("feature %d, id %d: %v", i, f.Properties.Id, err)
+	if mode == Strict {
+		return t, false, err
+	}
+	log.Printf("WARNING: skipping %s: %v", geojsonFile, err)
+	return t, false, nil
+}
+
+// ReadTruth reads the ground-truth boxes of an xview geojson. Lenient skips
+// the features with malformed bounds, logging each, while Strict fails on
+// the first.
+func ReadTruth(geojsonFile string, mode ParseMode) ([]Truth, error) {
+	ref, err := ReadFeatures(geojsonFile)
+	if err != nil {
+		return nil, err
+	}
+	truth := make([]Truth, 0, len(ref.Features))
+	for i, rf := range ref.Features {
+		t, ok, err := featureTruth(geojsonFile, i, rf, mode)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			truth = append(truth, t)
+		}
+	}
+	return truth, nil
+}
@@ -0,0 +1,240 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+)
+
+// tiff tags that georeference an image
+const (
+	tagModelPixelScale  = 33550
+	tagModelTiepoint    = 33922
+	tagGeoKeyDirectory  = 34735
+	tagGeoDoubleParams  = 34736
+	tagGeoAsciiParams   = 34737
+	tagModelTransform   = 34264
+	tiffShort           = 3
+	tiffLong            = 4
+	tiffASCII           = 2
+	tiffDouble          = 12
+	tiffSampleFloat     = 3
+	tiffPhotometricGray = 1
+)
+
+var geoTags = []uint16{tagModelPixelScale, tagModelTiepoint, tagModelTransform, tagGeoKeyDirectory, tagGeoDoubleParams, tagGeoAsciiParams}
+
+// the georeferencing tags of a GeoTIFF, by tag
+type GeoTags map[uint16]tiffField
+
+type tiffField struct {
+	Type  uint16
+	Count uint32
+	// the values, little endian
+	Data []byte
+}
+
+// ReadGeoTags reads the georeferencing tags of the first image of a tiff,
+// which are empty when it has none
+func ReadGeoTags(tiffFile string) (GeoTags, error) {
+	b, err := ioutil.ReadFile(tiffFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 8 {
+		return nil, fmt.Errorf("%s: not a tiff", tiffFile)
+	}
+	var order binary.ByteOrder
+	switch string(b[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%s: not a tiff", tiffFile)
+	}
+
+	ifd := int(order.Uint32(b[4:]))
+	if ifd+2 > len(b) {
+		return nil, fmt.Errorf("%s: truncated", tiffFile)
+	}
+	n := int(order.Uint16(b[ifd:]))
+	ret := make(GeoTags)
+	for i := 0; i < n; i++ {
+		e := ifd + 2 + 12*i
+		if e+12 > len(b) {
+			return nil, fmt.Errorf("%s: truncated", tiffFile)
+		}
+		tag := order.Uint16(b[e:])
+		if !isGeoTag(tag) {
+			continue
+		}
+		f := tiffField{Type: order.Uint16(b[e+2:]), Count: order.Uint32(b[e+4:])}
+		size := tiffSize(f.Type)
+		if size == 0 {
+			return nil, fmt.Errorf("%s: tag %d has unknown type %d", tiffFile, tag, f.Type)
+		}
+		at, end := e+8, e+8+size*int(f.Count)
+		if size*int(f.Count) > 4 {
+			at = int(order.Uint32(b[e+8:]))
+			end = at + size*int(f.Count)
+		}
+		if end > len(b) {
+			return nil, fmt.Errorf("%s: truncated", tiffFile)
+		}
+		f.Data = toLittleEndian(b[at:end], size, order)
+		ret[tag] = f
+	}
+	return ret, nil
+}
+
+func isGeoTag(tag uint16) bool {
+	for _, t := range geoTags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func tiffSize(typ uint16) int {
+	switch typ {
+	case 1, tiffASCII, 6, 7:
+		return 1
+	case tiffShort, 8:
+		return 2
+	case tiffLong, 9, 11:
+		return 4
+	case 5, 10, tiffDouble:
+		return 8
+	}
+	return 0
+}
+
+func toLittleEndian(b []byte, size int, order binary.ByteOrder) []byte {
+	ret := make([]byte, len(b))
+	copy(ret, b)
+	if order == binary.BigEndian && size > 1 {
+		for i := 0; i+size <= len(ret); i += size {
+			for j := 0; j < size/2; j++ {
+				ret[i+j], ret[i+size-1-j] = ret[i+size-1-j], ret[i+j]
+			}
+		}
+	}
+	return ret
+}
+
+// Scaled georeferences an image of pixels s times the size of the one the
+// tags are of, with the same origin
+func (g GeoTags) Scaled(s float64) GeoTags {
+	ret := make(GeoTags, len(g))
+	for tag, f := range g {
+		ret[tag] = f
+	}
+	if f, ok := g[tagModelPixelScale]; ok && f.Type == tiffDouble && f.Count >= 2 {
+		ret[tagModelPixelScale] = scaleDoubles(f, s, 0, 1)
+	}
+	if f, ok := g[tagModelTiepoint]; ok && f.Type == tiffDouble {
+		// raster points of each tie of raster to model points
+		at := make([]int, 0)
+		for i := 0; i+6 <= int(f.Count); i += 6 {
+			at = append(at, i, i+1)
+		}
+		ret[tagModelTiepoint] = scaleDoubles(f, 1/s, at...)
+	}
+	if f, ok := g[tagModelTransform]; ok && f.Type == tiffDouble && f.Count == 16 {
+		ret[tagModelTransform] = scaleDoubles(f, s, 0, 1, 4, 5)
+	}
+	return ret
+}
+
+func scaleDoubles(f tiffField, s float64, at ...int) tiffField {
+	data := make([]byte, len(f.Data))
+	copy(data, f.Data)
+	for _, i := range at {
+		v := math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:]))
+		binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(v*s))
+	}
+	return tiffField{Type: f.Type, Count: f.Count, Data: data}
+}
+
+// WriteGeoTIFF writes a single band of float32 values, row by row, as an
+// uncompressed tiff georeferenced by geo when it is not empty
+func WriteGeoTIFF(w io.Writer, cols, rows int, values []float32, geo GeoTags) error {
+	if len(values) != cols*rows {
+		return fmt.Errorf("%d values for %dx%d", len(values), cols, rows)
+	}
+	short := func(v uint16) tiffField {
+		b := make([]byte, 2)
+		binary.LittleEndian.PutUint16(b, v)
+		return tiffField{Type: tiffShort, Count: 1, Data: b}
+	}
+	long := func(v uint32) tiffField {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, v)
+		return tiffField{Type: tiffLong, Count: 1, Data: b}
+	}
+	const dataAt = 8
+	fields := map[uint16]tiffField{
+		256: long(uint32(cols)),
+		257: long(uint32(rows)),
+		258: short(32),
+		259: short(1),
+		262: short(tiffPhotometricGray),
+		273: long(dataAt),
+		277: short(1),
+		278: long(uint32(rows)),
+		279: long(uint32(4 * len(values))),
+		284: short(1),
+		339: short(tiffSampleFloat),
+	}
+	for tag, f := range geo {
+		fields[tag] = f
+	}
+	tags := make([]int, 0, len(fields))
+	for tag := range fields {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	buf.WriteString("II*\x00")
+	ifd := dataAt + 4*len(values)
+	ifd += ifd % 2
+	binary.Write(&buf, le, uint32(ifd))
+	binary.Write(&buf, le, values)
+	for buf.Len() < ifd {
+		buf.WriteByte(0)
+	}
+
+	// values too long for an entry follow the ifd
+	extra := ifd + 2 + 12*len(tags) + 4
+	var tail bytes.Buffer
+	binary.Write(&buf, le, uint16(len(tags)))
+	for _, tag := range tags {
+		f := fields[uint16(tag)]
+		binary.Write(&buf, le, uint16(tag))
+		binary.Write(&buf, le, f.Type)
+		binary.Write(&buf, le, f.Count)
+		if len(f.Data) <= 4 {
+			v := make([]byte, 4)
+			copy(v, f.Data)
+			buf.Write(v)
+			continue
+		}
+		binary.Write(&buf, le, uint32(extra+tail.Len()))
+		tail.Write(f.Data)
+		if tail.Len()%2 == 1 {
+			tail.WriteByte(0)
+		}
+	}
+	binary.Write(&buf, le, uint32(0))
+	buf.Write(tail.Bytes())
+	_, err := w.Write(buf.Bytes())
+	return err
+}
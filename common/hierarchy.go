@@ -0,0 +1,144 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// a tree of classes, eg. Small Car and Bus under Passenger Vehicle
+type Hierarchy struct {
+	Parents map[CID]CID
+	// names of classes that are not in the labels, eg. new groups
+	Names map[CID]string
+}
+
+// ReadHierarchy reads a hierarchy file of `class parent [name]' lines, where
+// name optionally names the parent. Blank lines and lines starting with #
+// are ignored.
+func ReadHierarchy(hierarchyFile string) (Hierarchy, error) {
+	ret := Hierarchy{
+		Parents: make(map[CID]CID),
+		Names:   make(map[CID]string),
+	}
+	file, err := os.Open(hierarchyFile)
+	if err != nil {
+		return ret, err
+	}
+	defer file.Close()
+
+	err = ReadLines(file, hierarchyFile, Strict, func(line string) error {
+		splits := strings.Fields(line)
+		if len(splits) < 2 {
+			return fmt.Errorf("expected `class parent [name]'")
+		}
+		class, err := strconv.Atoi(splits[0])
+		if err != nil {
+			return err
+		}
+		parent, err := strconv.Atoi(splits[1])
+		if err != nil {
+			return err
+		}
+		if p, ok := ret.Parents[CID(class)]; ok && p != CID(parent) {
+			return fmt.Errorf("class %d already has parent %d", class, p)
+		}
+		ret.Parents[CID(class)] = CID(parent)
+		if len(splits) > 2 {
+			ret.Names[CID(parent)] = strings.Join(splits[2:], " ")
+		}
+		return nil
+	})
+	if err != nil {
+		return ret, err
+	}
+
+	// a cycle would never reach a root
+	for c := range ret.Parents {
+		if _, err := ret.path(c); err != nil {
+			return ret, fmt.Errorf("%s: %v", hierarchyFile, err)
+		}
+	}
+	return ret, nil
+}
+
+// path from the root down to class
+func (h Hierarchy) path(class CID) ([]CID, error) {
+	ret := []CID{class}
+	seen := map[CID]bool{class: true}
+	for {
+		p, ok := h.Parents[ret[0]]
+		if !ok {
+			return ret, nil
+		}
+		if seen[p] {
+			return nil, fmt.Errorf("class %d is its own ancestor", p)
+		}
+		seen[p] = true
+		ret = append([]CID{p}, ret...)
+	}
+}
+
+// Depth of a class below its root, roots are at 0
+func (h Hierarchy) Depth(class CID) int {
+	p, _ := h.path(class)
+	return len(p) - 1
+}
+
+// Ancestor of class at level, where roots are level 0. Classes above level
+// are their own ancestor.
+func (h Hierarchy) Ancestor(class CID, level int) CID {
+	p, _ := h.path(class)
+	if level < 0 || level >= len(p) {
+		return class
+	}
+	return p[level]
+}
+
+// Labels adds the names of groups to labels
+func (h Hierarchy) Labels(labels map[CID]string) map[CID]string {
+	ret := make(map[CID]string, len(labels)+len(h.Names))
+	for k, v := range h.Names {
+		ret[k] = v
+	}
+	for k, v := range labels {
+		ret[k] = v
+	}
+	return ret
+}
+
+// RollUp replaces the classes of truth and detections in each scene with
+// their ancestor at level, so that eg. a Small Car detected as a Bus is
+// correct at the Passenger Vehicle level
+func (h Hierarchy) RollUp(scenes []Scene, level int) []Scene {
+	ret := make([]Scene, len(scenes))
+	for i, s := range scenes {
+		ret[i] = Scene{
+			Name:    s.Name,
+			Truth:   h.RollUpTruth(s.Truth, level),
+			Detects: h.RollUpDetects(s.Detects, level),
+		}
+	}
+	return ret
+}
+
+// RollUpTruth returns a copy of truth with classes at level
+func (h Hierarchy) RollUpTruth(truth []Truth, level int) []Truth {
+	ret := make([]Truth, len(truth))
+	for i, t := range truth {
+		t.Class = h.Ancestor(t.Class, level)
+		ret[i] = t
+	}
+	return ret
+}
+
+// RollUpDetects returns a copy of detects with classes at level
+func (h Hierarchy) RollUpDetects(detects []Detect, level int) []Detect {
+	ret := make([]Detect, len(detects))
+	for i, d := range detects {
+		d.Class = h.Ancestor(d.Class, level)
+		ret[i] = d
+	}
+	return ret
+}
@@ -0,0 +1,196 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+)
+
+// a polygon in image coordinates
+type Polygon [][2]float64
+
+// an area of an image, eg. cloud cover, where detections are not scored
+type Region struct {
+	// the scene the region applies to, or all scenes when empty
+	Image    string
+	Polygons []Polygon
+}
+
+type regionCollection struct {
+	Features []struct {
+		Properties struct {
+			Bounds string `json:"bounds_imcoords"`
+			Image  string `json:"image_id"`
+		}
+		Geometry *struct {
+			Type        string
+			Coordinates json.RawMessage
+		}
+	}
+}
+
+// ReadRegions reads ignore regions from a geojson of Polygon or MultiPolygon
+// features in image coordinates, or of features with bounds_imcoords like
+// the ground truth. Features with an image_id only apply to that scene.
+func ReadRegions(geojsonFile string) ([]Region, error) {
+	b, err := ioutil.ReadFile(geojsonFile)
+	if err != nil {
+		return nil, err
+	}
+	var fc regionCollection
+	if err := json.Unmarshal(b, &fc); err != nil {
+		return nil, fmt.Errorf("%s: %v", geojsonFile, err)
+	}
+
+	ret := make([]Region, 0, len(fc.Features))
+	for i, f := range fc.Features {
+		_, name, _ := SplitPath(f.Properties.Image)
+		if f.Properties.Image == "" {
+			name = ""
+		}
+		r := Region{Image: name}
+		switch {
+		case f.Properties.Bounds != "":
+			b, err := ParseBounds(f.Properties.Bounds)
+			if err != nil {
+				return nil, fmt.Errorf("%s: feature %d: %v", geojsonFile, i, err)
+			}
+			r.Polygons = []Polygon{RectPolygon(b)}
+		case f.Geometry != nil && f.Geometry.Type == "Polygon":
+			var rings []Polygon
+			if err := json.Unmarshal(f.Geometry.Coordinates, &rings); err != nil {
+				return nil, fmt.Errorf("%s: feature %d: %v", geojsonFile, i, err)
+			}
+			// holes are not supported, only the outer ring is used
+			if len(rings) > 0 {
+				r.Polygons = rings[:1]
+			}
+		case f.Geometry != nil && f.Geometry.Type == "MultiPolygon":
+			var polys [][]Polygon
+			if err := json.Unmarshal(f.Geometry.Coordinates, &polys); err != nil {
+				return nil, fmt.Errorf("%s: feature %d: %v", geojsonFile, i, err)
+			}
+			for _, rings := range polys {
+				if len(rings) > 0 {
+					r.Polygons = append(r.Polygons, rings[0])
+				}
+			}
+		default:
+			return nil, fmt.Errorf("%s: feature %d: expected a Polygon, MultiPolygon or bounds_imcoords", geojsonFile, i)
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// RectPolygon returns the corners of a rectangle as a polygon
+func RectPolygon(r image.Rectangle) Polygon {
+	x0, y0, x1, y1 := float64(r.Min.X), float64(r.Min.Y), float64(r.Max.X), float64(r.Max.Y)
+	return Polygon{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}}
+}
+
+// Area of the polygon by the shoelace formula
+func (p Polygon) Area() float64 {
+	a := 0.0
+	for i := range p {
+		j := (i + 1) % len(p)
+		a += p[i][0]*p[j][1] - p[j][0]*p[i][1]
+	}
+	return math.Abs(a) / 2
+}
+
+// Clip the polygon to a rectangle, by Sutherland-Hodgman
+func (p Polygon) Clip(r image.Rectangle) Polygon {
+	x0, y0, x1, y1 := float64(r.Min.X), float64(r.Min.Y), float64(r.Max.X), float64(r.Max.Y)
+	edges := []struct {
+		inside func(q [2]float64) bool
+		cross  func(a, b [2]float64) [2]float64
+	}{
+		{func(q [2]float64) bool { return q[0] >= x0 }, func(a, b [2]float64) [2]float64 { return atX(a, b, x0) }},
+		{func(q [2]float64) bool { return q[0] <= x1 }, func(a, b [2]float64) [2]float64 { return atX(a, b, x1) }},
+		{func(q [2]float64) bool { return q[1] >= y0 }, func(a, b [2]float64) [2]float64 { return atY(a, b, y0) }},
+		{func(q [2]float64) bool { return q[1] <= y1 }, func(a, b [2]float64) [2]float64 { return atY(a, b, y1) }},
+	}
+
+	out := p
+	for _, e := range edges {
+		in := out
+		out = make(Polygon, 0, len(in)+4)
+		for i := range in {
+			cur, prev := in[i], in[(i+len(in)-1)%len(in)]
+			if e.inside(cur) {
+				if !e.inside(prev) {
+					out = append(out, e.cross(prev, cur))
+				}
+				out = append(out, cur)
+			} else if e.inside(prev) {
+				out = append(out, e.cross(prev, cur))
+			}
+		}
+		if len(out) == 0 {
+			break
+		}
+	}
+	return out
+}
+
+func atX(a, b [2]float64, x float64) [2]float64 {
+	return [2]float64{x, a[1] + (b[1]-a[1])*(x-a[0])/(b[0]-a[0])}
+}
+
+func atY(a, b [2]float64, y float64) [2]float64 {
+	return [2]float64{a[0] + (b[0]-a[0])*(y-a[1])/(b[1]-a[1]), y}
+}
+
+// IoA is the largest fraction of the area of r inside any one of the polygons
+// of the regions
+func IoA(r image.Rectangle, regions []Region) float32 {
+	area := float64(Area(r))
+	if area == 0 {
+		return 0
+	}
+	best := 0.0
+	for _, reg := range regions {
+		for _, p := range reg.Polygons {
+			if a := p.Clip(r).Area() / area; a > best {
+				best = a
+			}
+		}
+	}
+	return float32(math.Min(best, 1))
+}
+
+// IgnoreRegions marks truth with at least minIoa of its area inside the
+// regions that apply to the scene as Ignore, and drops the detections that
+// are, so that they are neither true nor false positives
+func (s Scene) IgnoreRegions(regions []Region, minIoa float32) Scene {
+	here := make([]Region, 0)
+	for _, r := range regions {
+		if r.Image == "" || r.Image == s.Name {
+			here = append(here, r)
+		}
+	}
+	if len(here) == 0 {
+		return s
+	}
+
+	ret := Scene{
+		Name:    s.Name,
+		Truth:   make([]Truth, len(s.Truth)),
+		Detects: make([]Detect, 0, len(s.Detects)),
+	}
+	for i, t := range s.Truth {
+		if IoA(t.Bounds, here) >= minIoa {
+			t.Ignore = true
+		}
+		ret.Truth[i] = t
+	}
+	for _, d := range s.Detects {
+		if IoA(d.Bounds, here) < minIoa {
+			ret.Detects = append(ret.Detects, d)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,206 @@
+package common
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// buckets boxes by the cells of a uniform grid that they overlap, so that
+// the boxes that may intersect another are found without testing every pair.
+// Queries are not safe to run concurrently.
+type GridIndex struct {
+	cell  int
+	boxes []image.Rectangle
+	cells map[image.Point][]int
+	// boxes that span more cells than are worth bucketing, which every
+	// query tests
+	wide []int
+	// the first and last cells of the bucketed boxes
+	min, max image.Point
+	// the query each box was last returned by, to return it once per query
+	stamp []int
+	query int
+}
+
+// boxes and queries may span this many cells, or as many as there are
+// boxes, before they are tested one by one instead
+const gridMaxSpan = 64
+
+// NewGridIndex indexes boxes by position in the slice, with cells of cell
+// pixels, or twice the mean box size when cell is 0. Empty boxes intersect
+// nothing and are not indexed.
+func NewGridIndex(boxes []image.Rectangle, cell int) *GridIndex {
+	if cell <= 0 {
+		cell = gridCell(boxes)
+	}
+	g := &GridIndex{
+		cell:  cell,
+		boxes: append([]image.Rectangle(nil), boxes...),
+		cells: make(map[image.Point][]int),
+		stamp: make([]int, len(boxes)),
+		min:   image.Pt(math.MaxInt32, math.MaxInt32),
+		max:   image.Pt(math.MinInt32, math.MinInt32),
+	}
+	for i := range boxes {
+		g.insert(i)
+	}
+	return g
+}
+
+func (g *GridIndex) insert(i int) {
+	b := g.boxes[i]
+	if b.Empty() {
+		return
+	}
+	min, max := g.span(b)
+	if g.tooWide(min, max) {
+		g.wide = append(g.wide, i)
+		return
+	}
+	for y := min.Y; y <= max.Y; y++ {
+		for x := min.X; x <= max.X; x++ {
+			p := image.Point{X: x, Y: y}
+			g.cells[p] = append(g.cells[p], i)
+		}
+	}
+	if min.X < g.min.X {
+		g.min.X = min.X
+	}
+	if min.Y < g.min.Y {
+		g.min.Y = min.Y
+	}
+	if max.X > g.max.X {
+		g.max.X = max.X
+	}
+	if max.Y > g.max.Y {
+		g.max.Y = max.Y
+	}
+}
+
+// Add indexes another box, returning its position
+func (g *GridIndex) Add(b image.Rectangle) int {
+	g.boxes = append(g.boxes, b)
+	g.stamp = append(g.stamp, 0)
+	i := len(g.boxes) - 1
+	g.insert(i)
+	return i
+}
+
+// NewTruthIndex indexes the bounds of truth
+func NewTruthIndex(truth []Truth) *GridIndex {
+	boxes := make([]image.Rectangle, len(truth))
+	for i, t := range truth {
+		boxes[i] = t.Bounds
+	}
+	return NewGridIndex(boxes, 0)
+}
+
+// Query returns, in increasing order, the positions of the boxes that may
+// intersect r, which includes every box that does
+func (g *GridIndex) Query(r image.Rectangle) []int {
+	if r.Empty() {
+		return nil
+	}
+	g.query++
+	ret := make([]int, 0)
+	add := func(i int) {
+		if g.stamp[i] != g.query {
+			g.stamp[i] = g.query
+			ret = append(ret, i)
+		}
+	}
+	for _, i := range g.wide {
+		if g.boxes[i].Overlaps(r) {
+			add(i)
+		}
+	}
+
+	// cells beyond the bucketed boxes are empty
+	min, max := g.span(r)
+	if min.X < g.min.X {
+		min.X = g.min.X
+	}
+	if min.Y < g.min.Y {
+		min.Y = g.min.Y
+	}
+	if max.X > g.max.X {
+		max.X = g.max.X
+	}
+	if max.Y > g.max.Y {
+		max.Y = g.max.Y
+	}
+	if g.tooWide(min, max) {
+		for i, b := range g.boxes {
+			if b.Overlaps(r) {
+				add(i)
+			}
+		}
+	} else {
+		for y := min.Y; y <= max.Y; y++ {
+			for x := min.X; x <= max.X; x++ {
+				for _, i := range g.cells[image.Point{X: x, Y: y}] {
+					add(i)
+				}
+			}
+		}
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// Overlaps reports whether any of the boxes intersects r
+func (g *GridIndex) Overlaps(r image.Rectangle) bool {
+	for _, i := range g.Query(r) {
+		if g.boxes[i].Overlaps(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// the first and last cells covered by r
+func (g *GridIndex) span(r image.Rectangle) (image.Point, image.Point) {
+	return image.Point{X: floorDiv(r.Min.X, g.cell), Y: floorDiv(r.Min.Y, g.cell)},
+		image.Point{X: floorDiv(r.Max.X-1, g.cell), Y: floorDiv(r.Max.Y-1, g.cell)}
+}
+
+// whether the cells from min to max are more than are worth visiting
+func (g *GridIndex) tooWide(min, max image.Point) bool {
+	limit := len(g.boxes)
+	if limit < gridMaxSpan {
+		limit = gridMaxSpan
+	}
+	w, h := int64(max.X)-int64(min.X)+1, int64(max.Y)-int64(min.Y)+1
+	if w <= 0 || h <= 0 {
+		return false
+	}
+	return w > int64(limit) || h > int64(limit) || w*h > int64(limit)
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+// twice the mean box size, which keeps a box in a few cells
+func gridCell(boxes []image.Rectangle) int {
+	sum, n := 0, 0
+	for _, b := range boxes {
+		if !b.Empty() {
+			z := b.Size()
+			sum += z.X + z.Y
+			n++
+		}
+	}
+	if n == 0 {
+		return 64
+	}
+	if cell := sum / n; cell > 1 {
+		return cell
+	}
+	return 1
+}
@@ -0,0 +1,189 @@
+package common
+
+import (
+	"image"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// a wide-area scene of n small truth, each with two jittered detections of a
+// random class
+func denseScene(rnd *rand.Rand, n int) ([]Truth, []Detect) {
+	truth := make([]Truth, n)
+	detects := make([]Detect, 0, 2*n)
+	for i := range truth {
+		x, y := rnd.Intn(3000)-100, rnd.Intn(3000)-100
+		w, h := 5+rnd.Intn(30), 5+rnd.Intn(30)
+		truth[i] = Truth{Id: TID(i), Bounds: image.Rect(x, y, x+w, y+h), Class: CID(18 + rnd.Intn(3))}
+		for k := 0; k < 2; k++ {
+			dx, dy := rnd.Intn(11)-5, rnd.Intn(11)-5
+			detects = append(detects, Detect{
+				Id:         DID(len(detects)),
+				Bounds:     image.Rect(x+dx, y+dy, x+w+dx, y+h+dy),
+				Class:      CID(18 + rnd.Intn(3)),
+				Confidence: rnd.Float32(),
+			})
+		}
+	}
+	return truth, detects
+}
+
+func acceptAll(Detect) bool { return true }
+
+// MatchDetections testing every pair
+func bruteMatch(truth []Truth, detects []Detect, minIou float32) map[TID]Match {
+	matched := make(map[TID]Match)
+	for _, d := range detects {
+		for _, t := range truth {
+			if _, here := matched[t.Id]; !here {
+				iou := IoU(t.Bounds, d.Bounds)
+				if iou > 0 && iou >= minIou {
+					matched[t.Id] = Match{T: t, D: d, IoU: iou}
+					break
+				}
+			}
+		}
+	}
+	return matched
+}
+
+// NMS testing every pair
+func bruteNMS(detects []Detect, maxIou float32) []Detect {
+	sorted := make([]Detect, len(detects))
+	copy(sorted, detects)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Confidence > sorted[j].Confidence
+	})
+	suppressed := make([]bool, len(sorted))
+	ret := make([]Detect, 0, len(sorted))
+	for i, d := range sorted {
+		if suppressed[i] {
+			continue
+		}
+		ret = append(ret, d)
+		for j := i + 1; j < len(sorted); j++ {
+			if sorted[j].Class == d.Class && IoU(d.Bounds, sorted[j].Bounds) > maxIou {
+				suppressed[j] = true
+			}
+		}
+	}
+	return ret
+}
+
+func TestQuery(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	boxes := make([]image.Rectangle, 3000)
+	for i := range boxes {
+		x, y := rnd.Intn(2000)-1000, rnd.Intn(2000)-1000
+		boxes[i] = image.Rect(x, y, x+rnd.Intn(60), y+rnd.Intn(60))
+	}
+	// a few that span most of the scene
+	boxes[7] = image.Rect(-1000, -1000, 1000, 1000)
+	boxes[8] = image.Rect(-1e6, 0, 1e6, 1)
+	for _, cell := range []int{0, 1, 16, 5000} {
+		g := NewGridIndex(boxes, cell)
+		for k := 0; k < 500; k++ {
+			x, y := rnd.Intn(2400)-1200, rnd.Intn(2400)-1200
+			r := image.Rect(x, y, x+rnd.Intn(300), y+rnd.Intn(300))
+			got := make(map[int]bool)
+			for _, i := range g.Query(r) {
+				got[i] = true
+			}
+			for i, b := range boxes {
+				if b.Overlaps(r) && !got[i] {
+					t.Fatalf("cell %d: %v missing %d %v", cell, r, i, b)
+				}
+			}
+		}
+	}
+}
+
+func TestQueryHuge(t *testing.T) {
+	rnd := rand.New(rand.NewSource(4))
+	boxes := make([]image.Rectangle, 1000)
+	for i := range boxes {
+		x, y := rnd.Intn(5000), rnd.Intn(5000)
+		boxes[i] = image.Rect(x, y, x+20, y+20)
+	}
+	done := make(chan []int)
+	go func() {
+		g := NewGridIndex(append(boxes, image.Rect(-1e6, -1e6, 1e6, 1e6)), 0)
+		done <- g.Query(image.Rect(-1e6, -1e6, 1e6, 1e6))
+	}()
+	select {
+	case got := <-done:
+		if len(got) != len(boxes)+1 {
+			t.Errorf("got %d boxes, want %d", len(got), len(boxes)+1)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("query of a huge box did not finish")
+	}
+}
+
+func TestAddOverlaps(t *testing.T) {
+	rnd := rand.New(rand.NewSource(5))
+	g := NewGridIndex([]image.Rectangle{image.Rect(0, 0, 200, 100)}, 64)
+	placed := []image.Rectangle{image.Rect(0, 0, 200, 100)}
+	for k := 0; k < 2000; k++ {
+		x, y := rnd.Intn(2000), rnd.Intn(2000)
+		r := image.Rect(x, y, x+20+rnd.Intn(100), y+10+rnd.Intn(20))
+		want := false
+		for _, p := range placed {
+			want = want || p.Overlaps(r)
+		}
+		if got := g.Overlaps(r); got != want {
+			t.Fatalf("%v: got %v, want %v", r, got, want)
+		}
+		if !want {
+			if i := g.Add(r); i != len(placed) {
+				t.Fatalf("added at %d, want %d", i, len(placed))
+			}
+			placed = append(placed, r)
+		}
+	}
+}
+
+func TestIndexedMatchesBrute(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	for k := 0; k < 10; k++ {
+		truth, detects := denseScene(rnd, 2000)
+		m, _, _ := MatchDetections(truth, detects, .5, acceptAll)
+		if !reflect.DeepEqual(m, bruteMatch(truth, detects, .5)) {
+			t.Fatal("MatchDetections differs from testing every pair")
+		}
+		if !reflect.DeepEqual(NMS(detects, .3), bruteNMS(detects, .3)) {
+			t.Fatal("NMS differs from testing every pair")
+		}
+	}
+}
+
+func BenchmarkMatchDetections(b *testing.B) {
+	truth, detects := denseScene(rand.New(rand.NewSource(1)), 10000)
+	b.Run("indexed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			MatchDetections(truth, detects, .5, acceptAll)
+		}
+	})
+	b.Run("brute", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bruteMatch(truth, detects, .5)
+		}
+	})
+}
+
+func BenchmarkNMS(b *testing.B) {
+	_, detects := denseScene(rand.New(rand.NewSource(1)), 10000)
+	b.Run("indexed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			NMS(detects, .3)
+		}
+	})
+	b.Run("brute", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bruteNMS(detects, .3)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ReadLabels reads a class mapping dict of `id:name' lines, eg. labels.txt,
+// where the delimiter may also be a tab or comma
+func ReadLabels(labelsFile string, mode ParseMode) (map[CID]string, error) {
+	file, err := os.Open(labelsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	labels := make(map[CID]string)
+	err = ReadLines(file, labelsFile, mode, func(line string) error {
+		i := strings.IndexAny(line, ":\t,")
+		if i < 0 {
+			return fmt.Errorf("expected id:name")
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(line[:i]))
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSpace(line[i+1:])
+		if name == "" {
+			return fmt.Errorf("class %d has no name", id)
+		}
+		labels[CID(id)] = name
+		return nil
+	})
+	return labels, err
+}
+
+// LabelName returns the label of a class, or its id when it is not mapped
+func LabelName(labels map[CID]string, class CID) string {
+	if name, ok := labels[class]; ok {
+		return name
+	}
+	return strconv.Itoa(int(class))
+}
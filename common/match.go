@@ -0,0 +1,126 @@
+package common
+
+import (
+	"fmt"
+)
+
+// MatchDetections greedily matches each accepted detection, in the order
+// given, to the first unmatched truth of any class with an IoU of at least
+// minIou.  Returns the matches by truth, the count of unmatched detections
+// by class, and the unmatched detections.
+func MatchDetections(truth []Truth, detects []Detect, minIou float32, accept func(Detect) bool) (map[TID]Match, map[CID]int, []Detect) {
+	matched := make(map[TID]Match, len(truth))
+	unmatched := make(map[CID]int)
+	fds := make([]Detect, 0)
+	index := NewTruthIndex(truth)
+
+	for _, d := range detects {
+		if accept(d) {
+			found := false
+			// only truth that intersects can match, in the order given
+			for _, j := range index.Query(d.Bounds) {
+				t := truth[j]
+				if _, here := matched[t.Id]; !here {
+					// calculate IOU if overlapping
+					iou := IoU(t.Bounds, d.Bounds)
+					if iou > 0 && iou >= minIou {
+						matched[t.Id] = Match{T: t, D: d, IoU: iou}
+						found = true
+						break
+					}
+				}
+			}
+
+			if !found {
+				// false-positive due to non intersecting box
+				// or a duplicate, see AnalyzeErrors for the breakdown
+				fds = append(fds, d)
+				unmatched[d.Class]++
+			}
+		}
+	}
+	return matched, unmatched, fds
+}
+
+// GetSceneConfusionMatrix matches the accepted detections of a scene and
+// builds its confusion matrix
+func GetSceneConfusionMatrix(s Scene, minIou float32, accept func(Detect) bool) ConfusionMatrix {
+	gtc := make(map[CID]int)
+	for _, t := range s.Truth {
+		gtc[t.Class]++
+	}
+	matched, unmatched, _ := MatchDetections(s.Truth, s.Detects, minIou, accept)
+	cm, _ := GetConfusionMatrix(gtc, matched, unmatched)
+	return cm
+}
+
+// how a detection or truth fared in a scene matched by MatchDetections
+type Outcome int
+
+const (
+	OutcomeTP Outcome = iota
+	OutcomeFP
+	// matched truth of another class
+	OutcomeMisclassified
+	// truth no detection matched
+	OutcomeMissed
+)
+
+// Outcomes in the order of a legend
+var Outcomes = []Outcome{OutcomeTP, OutcomeFP, OutcomeMisclassified, OutcomeMissed}
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeTP:
+		return "tp"
+	case OutcomeFP:
+		return "fp"
+	case OutcomeMisclassified:
+		return "misclassified"
+	case OutcomeMissed:
+		return "missed"
+	}
+	return fmt.Sprintf("Outcome(%d)", int(o))
+}
+
+// ParseOutcome parses the String of an outcome
+func ParseOutcome(s string) (Outcome, error) {
+	for _, o := range Outcomes {
+		if o.String() == s {
+			return o, nil
+		}
+	}
+	return 0, fmt.Errorf("outcome must be tp, fp, misclassified, or missed, not %q", s)
+}
+
+// a detection, or truth when Missed, and what became of it
+type Reviewed struct {
+	Outcome Outcome
+	// the detection, unless Missed
+	D Detect
+	// the truth, unless a false positive
+	T Truth
+}
+
+// ReviewScene matches the accepted detections of a scene as score does, and
+// returns the false positives in the order of the detections followed by the
+// outcome of each truth in order
+func ReviewScene(s Scene, minIou float32, accept func(Detect) bool) []Reviewed {
+	matched, _, unmatched := MatchDetections(s.Truth, s.Detects, minIou, accept)
+	ret := make([]Reviewed, 0, len(unmatched)+len(s.Truth))
+	for _, d := range unmatched {
+		ret = append(ret, Reviewed{Outcome: OutcomeFP, D: d})
+	}
+	for _, t := range s.Truth {
+		m, ok := matched[t.Id]
+		switch {
+		case !ok:
+			ret = append(ret, Reviewed{Outcome: OutcomeMissed, T: t})
+		case m.D.Class != t.Class:
+			ret = append(ret, Reviewed{Outcome: OutcomeMisclassified, D: m.D, T: t})
+		default:
+			ret = append(ret, Reviewed{Outcome: OutcomeTP, D: m.D, T: t})
+		}
+	}
+	return ret
+}
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"image"
+	"sort"
+)
+
+// NMS keeps, in order of decreasing confidence, each detection that does
+// not overlap an already kept detection of its class by more than maxIou
+func NMS(detects []Detect, maxIou float32) []Detect {
+	sorted := make([]Detect, len(detects))
+	copy(sorted, detects)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Confidence > sorted[j].Confidence
+	})
+
+	boxes := make([]image.Rectangle, len(sorted))
+	for i, d := range sorted {
+		boxes[i] = d.Bounds
+	}
+	index := NewGridIndex(boxes, 0)
+
+	suppressed := make([]bool, len(sorted))
+	ret := make([]Detect, 0, len(sorted))
+	for i, d := range sorted {
+		if suppressed[i] {
+			continue
+		}
+		ret = append(ret, d)
+		for _, j := range index.Query(d.Bounds) {
+			if j > i && !suppressed[j] && sorted[j].Class == d.Class && IoU(d.Bounds, boxes[j]) > maxIou {
+				suppressed[j] = true
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,92 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// how readers handle malformed lines
+type ParseMode int
+
+const (
+	// skip malformed lines, logging each
+	Lenient ParseMode = iota
+	// fail on the first malformed line
+	Strict
+)
+
+// GetParseMode returns Strict when strict, else Lenient
+func GetParseMode(strict bool) ParseMode {
+	if strict {
+		return Strict
+	}
+	return Lenient
+}
+
+// a malformed line of a file
+type ParseError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+// ReadLines calls parse with each line of r, skipping blank lines and lines
+// starting with #. Lenient logs and skips the lines parse fails on, while
+// Strict returns the first as a ParseError. name is the file r is read from,
+// for errors.
+func ReadLines(r io.Reader, name string, mode ParseMode, parse func(line string) error) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := parse(line); err != nil {
+			perr := &ParseError{File: name, Line: n, Err: err}
+			if mode == Strict {
+				return perr
+			}
+			log.Printf("WARNING: skipping %v", perr)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// Fields splits a line on spaces, tabs, or commas
+func Fields(line string) []string {
+	return strings.FieldsFunc(line, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ','
+	})
+}
+
+// ParseRect parses the first four of fields as xmin,ymin,xmax,ymax
+func ParseRect(fields []string) (image.Rectangle, error) {
+	if len(fields) < 4 {
+		return image.Rectangle{}, fmt.Errorf("expected xmin ymin xmax ymax, got %d fields", len(fields))
+	}
+	var v [4]int
+	for i := range v {
+		n, err := strconv.Atoi(strings.TrimSpace(fields[i]))
+		if err != nil {
+			return image.Rectangle{}, err
+		}
+		v[i] = n
+	}
+	return image.Rectangle{
+		Min: image.Point{X: v[0], Y: v[1]},
+		Max: image.Point{X: v[2], Y: v[3]},
+	}, nil
+}
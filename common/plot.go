@@ -0,0 +1,229 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/fogleman/gg"
+	"golang.org/x/image/colornames"
+	"html"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	plotW, plotH = 640, 480
+	plotMargin   = 60
+)
+
+// PlotPRCurve renders a precision-recall curve to a png file, or to an svg
+// file when outfile ends in .svg
+func PlotPRCurve(curve PRCurve, title string, outfile string) error {
+	if strings.ToLower(filepath.Ext(outfile)) == ".svg" {
+		return plotPRCurveSVG(curve, title, outfile)
+	}
+	dc := gg.NewContext(plotW, plotH)
+	dc.SetColor(colornames.White)
+	dc.Clear()
+
+	x0, y0 := float64(plotMargin), float64(plotH-plotMargin)
+	pw, ph := float64(plotW-2*plotMargin), float64(plotH-2*plotMargin)
+	px := func(recall float64) float64 { return x0 + recall*pw }
+	py := func(precision float64) float64 { return y0 - precision*ph }
+
+	// grid and tick labels
+	dc.SetLineWidth(.5)
+	for i := 0; i <= 10; i++ {
+		v := float64(i) / 10
+		dc.SetColor(colornames.Lightgray)
+		dc.DrawLine(px(v), py(0), px(v), py(1))
+		dc.DrawLine(px(0), py(v), px(1), py(v))
+		dc.Stroke()
+
+		dc.SetColor(colornames.Black)
+		dc.DrawStringAnchored(fmt.Sprintf("%.1f", v), px(v), py(0)+12, .5, .5)
+		dc.DrawStringAnchored(fmt.Sprintf("%.1f", v), px(0)-6, py(v), 1, .5)
+	}
+
+	// axes
+	dc.SetLineWidth(1)
+	dc.SetColor(colornames.Black)
+	dc.DrawLine(px(0), py(0), px(1), py(0))
+	dc.DrawLine(px(0), py(0), px(0), py(1))
+	dc.Stroke()
+	dc.DrawStringAnchored("recall", px(.5), py(0)+30, .5, .5)
+	dc.Push()
+	dc.RotateAbout(gg.Radians(-90), px(0)-45, py(.5))
+	dc.DrawStringAnchored("precision", px(0)-45, py(.5), .5, .5)
+	dc.Pop()
+	dc.DrawStringAnchored(fmt.Sprintf("%s (AP %.4f)", title, curve.AveragePrecision()), plotW/2, plotMargin/2, .5, .5)
+
+	// curve
+	if len(curve) > 0 {
+		dc.SetLineWidth(2)
+		dc.SetColor(colornames.Steelblue)
+		dc.MoveTo(px(0), py(curve[0].Precision))
+		for _, p := range curve {
+			dc.LineTo(px(p.Recall), py(p.Precision))
+		}
+		dc.Stroke()
+	}
+
+	return dc.SavePNG(outfile)
+}
+
+// the same plot as PlotPRCurve draws, as vector shapes
+func plotPRCurveSVG(curve PRCurve, title string, outfile string) error {
+	f, err := os.Create(outfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+
+	x0, y0 := float64(plotMargin), float64(plotH-plotMargin)
+	pw, ph := float64(plotW-2*plotMargin), float64(plotH-2*plotMargin)
+	px := func(recall float64) float64 { return x0 + recall*pw }
+	py := func(precision float64) float64 { return y0 - precision*ph }
+	line := func(x1, y1, x2, y2 float64, c color.Color, width float64) {
+		fmt.Fprintf(bw, "<line x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"%s\" stroke-width=\"%g\"/>\n", x1, y1, x2, y2, cssColor(c), width)
+	}
+	text := func(s string, x, y float64, anchor, attrs string) {
+		fmt.Fprintf(bw, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"%s\"%s>%s</text>\n", x, y, anchor, attrs, html.EscapeString(s))
+	}
+
+	fmt.Fprintf(bw, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n", plotW, plotH, plotW, plotH)
+	fmt.Fprintf(bw, "<style>text { font-family: sans-serif; font-size: 12px; dominant-baseline: central; }</style>\n")
+	fmt.Fprintf(bw, "<rect width=\"%d\" height=\"%d\" fill=\"white\"/>\n", plotW, plotH)
+
+	// grid and tick labels
+	for i := 0; i <= 10; i++ {
+		v := float64(i) / 10
+		line(px(v), py(0), px(v), py(1), colornames.Lightgray, .5)
+		line(px(0), py(v), px(1), py(v), colornames.Lightgray, .5)
+		text(fmt.Sprintf("%.1f", v), px(v), py(0)+12, "middle", "")
+		text(fmt.Sprintf("%.1f", v), px(0)-6, py(v), "end", "")
+	}
+
+	// axes
+	line(px(0), py(0), px(1), py(0), colornames.Black, 1)
+	line(px(0), py(0), px(0), py(1), colornames.Black, 1)
+	text("recall", px(.5), py(0)+30, "middle", "")
+	text("precision", px(0)-45, py(.5), "middle", fmt.Sprintf(" transform=\"rotate(-90 %.1f %.1f)\"", px(0)-45, py(.5)))
+	text(fmt.Sprintf("%s (AP %.4f)", title, curve.AveragePrecision()), plotW/2, plotMargin/2, "middle", "")
+
+	// curve
+	if len(curve) > 0 {
+		fmt.Fprintf(bw, "<polyline fill=\"none\" stroke=\"%s\" stroke-width=\"2\" points=\"%.1f,%.1f", cssColor(colornames.Steelblue), px(0), py(curve[0].Precision))
+		for _, p := range curve {
+			fmt.Fprintf(bw, " %.1f,%.1f", px(p.Recall), py(p.Precision))
+		}
+		fmt.Fprintf(bw, "\"/>\n")
+	}
+	fmt.Fprintf(bw, "</svg>\n")
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("%s: %v", outfile, err)
+	}
+	return f.Close()
+}
+
+// RampColor maps v in [0,1] from white to dark blue
+func RampColor(v float64) color.Color {
+	v = math.Max(0, math.Min(1, v))
+	lerp := func(a, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*v)
+	}
+	return color.RGBA{R: lerp(255, 8), G: lerp(255, 48), B: lerp(255, 107), A: 255}
+}
+
+// PlotConfusionMatrix renders the full matrix as a labeled heatmap png,
+// shaded by fraction of the row when normalize is set, otherwise by count
+func PlotConfusionMatrix(c ConfusionMatrix, labels map[CID]string, normalize bool, outfile string) error {
+	m := GetMatrixCells(c)
+	n := len(m.Classes) + 1
+
+	// counts are only legible in larger cells
+	cell := 16.
+	if n <= 20 {
+		cell = 32.
+	}
+
+	// measure the longest name to size the margins
+	dc := gg.NewContext(1, 1)
+	names := 0.
+	for i := 0; i < n; i++ {
+		w, _ := dc.MeasureString(m.RowName(i, labels))
+		names = math.Max(names, w)
+		w, _ = dc.MeasureString(m.ColName(i, labels))
+		names = math.Max(names, w)
+	}
+	margin := names + 20
+	top := margin + 30
+
+	W := int(margin + cell*float64(n) + 20)
+	H := int(top + cell*float64(n) + 20)
+	dc = gg.NewContext(W, H)
+	dc.SetColor(colornames.White)
+	dc.Clear()
+
+	max := 0.
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			max = math.Max(max, m.Value(i, j, normalize))
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			v := m.Value(i, j, normalize)
+			x, y := margin+float64(j)*cell, top+float64(i)*cell
+			shade := 0.
+			if max > 0 {
+				shade = v / max
+			}
+			dc.SetColor(RampColor(shade))
+			dc.DrawRectangle(x, y, cell, cell)
+			dc.Fill()
+
+			if cell >= 32 && m.Cells[i][j] > 0 {
+				if shade > .5 {
+					dc.SetColor(colornames.White)
+				} else {
+					dc.SetColor(colornames.Black)
+				}
+				txt := fmt.Sprint(m.Cells[i][j])
+				if normalize {
+					txt = fmt.Sprintf("%.2f", v)
+				}
+				dc.DrawStringAnchored(txt, x+cell/2, y+cell/2, .5, .5)
+			}
+		}
+	}
+
+	// grid
+	dc.SetLineWidth(.5)
+	dc.SetColor(colornames.Lightgray)
+	for i := 0; i <= n; i++ {
+		dc.DrawLine(margin, top+float64(i)*cell, margin+float64(n)*cell, top+float64(i)*cell)
+		dc.DrawLine(margin+float64(i)*cell, top, margin+float64(i)*cell, top+float64(n)*cell)
+	}
+	dc.Stroke()
+
+	// labels, truth down the side and predictions rotated across the top
+	dc.SetColor(colornames.Black)
+	for i := 0; i < n; i++ {
+		dc.DrawStringAnchored(m.RowName(i, labels), margin-6, top+(float64(i)+.5)*cell, 1, .5)
+
+		x, y := margin+(float64(i)+.5)*cell, top-6
+		dc.Push()
+		dc.RotateAbout(gg.Radians(-90), x, y)
+		dc.DrawStringAnchored(m.ColName(i, labels), x, y, 0, .5)
+		dc.Pop()
+	}
+	dc.DrawStringAnchored("truth \\ predicted", 10, 15, 0, .5)
+
+	return dc.SavePNG(outfile)
+}
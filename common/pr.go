@@ -0,0 +1,154 @@
+package common
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// a point on a precision-recall curve, taken after accepting
+// every detection at or above the confidence
+type PRPoint struct {
+	Confidence float32
+	Precision  float64
+	Recall     float64
+}
+
+type PRCurve []PRPoint
+
+// GetPRCurves builds a precision-recall curve for each ground truth class.
+// Detections are matched greedily in order of decreasing confidence against
+// unmatched truth of the same class with an IoU of at least minIou.
+func GetPRCurves(truth []Truth, detects []Detect, minIou float32) map[CID]PRCurve {
+	return GetSceneCurves([]SceneResult{ScoreScene("", truth, detects, minIou)})
+}
+
+// GetOverallPRCurve builds a single precision-recall curve across all
+// classes, where a detection is only a true positive if the class matches
+func GetOverallPRCurve(truth []Truth, detects []Detect, minIou float32) PRCurve {
+	return GetOverallSceneCurve([]SceneResult{ScoreScene("", truth, detects, minIou)})
+}
+
+// MatchByClass greedily matches detections in order of decreasing confidence
+// against unmatched truth of the same class with an IoU of at least minIou.
+// Returns the detections in that order along with the truth each one matched,
+// or nil for a false positive.
+func MatchByClass(truth []Truth, detects []Detect, minIou float32) ([]Detect, []*Truth) {
+	tbc := make(map[CID][]Truth)
+	for _, t := range truth {
+		tbc[t.Class] = append(tbc[t.Class], t)
+	}
+	index := make(map[CID]*GridIndex, len(tbc))
+	for c, ts := range tbc {
+		index[c] = NewTruthIndex(ts)
+	}
+
+	sorted := make([]Detect, len(detects))
+	copy(sorted, detects)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Confidence > sorted[j].Confidence
+	})
+
+	used := make(map[TID]bool)
+	matches := make([]*Truth, len(sorted))
+	for i, d := range sorted {
+		ts := tbc[d.Class]
+		if len(ts) == 0 {
+			continue
+		}
+		for _, j := range index[d.Class].Query(d.Bounds) {
+			if used[ts[j].Id] {
+				continue
+			}
+			iou := IoU(ts[j].Bounds, d.Bounds)
+			if iou > 0 && iou >= minIou {
+				used[ts[j].Id] = true
+				matches[i] = &ts[j]
+				break
+			}
+		}
+	}
+	return sorted, matches
+}
+
+// NewPRCurve builds a curve from detections sorted by decreasing confidence,
+// with recall relative to total truth
+func NewPRCurve(scored []Scored, total int) PRCurve {
+	curve := make(PRCurve, 0, len(scored))
+	tp, fp := 0, 0
+	for _, d := range scored {
+		if d.TP {
+			tp++
+		} else {
+			fp++
+		}
+
+		recall := 0.0
+		if total > 0 {
+			recall = float64(tp) / float64(total)
+		}
+		curve = append(curve, PRPoint{
+			Confidence: d.Confidence,
+			Precision:  float64(tp) / float64(tp+fp),
+			Recall:     recall,
+		})
+	}
+	return curve
+}
+
+// MeanAP averages the average precision of each curve
+func MeanAP(curves map[CID]PRCurve) float64 {
+	if len(curves) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, c := range curves {
+		sum += c.AveragePrecision()
+	}
+	return sum / float64(len(curves))
+}
+
+// AveragePrecision computes the area under the interpolated curve,
+// where precision at each recall is the max precision at any higher recall
+func (c PRCurve) AveragePrecision() float64 {
+	if len(c) == 0 {
+		return 0
+	}
+	envelope := make([]float64, len(c))
+	max := 0.0
+	for i := len(c) - 1; i >= 0; i-- {
+		if c[i].Precision > max {
+			max = c[i].Precision
+		}
+		envelope[i] = max
+	}
+
+	ap := 0.0
+	prev := 0.0
+	for i, p := range c {
+		ap += (p.Recall - prev) * envelope[i]
+		prev = p.Recall
+	}
+	return ap
+}
+
+// WriteCSV writes the curve as rows of confidence,precision,recall
+func (c PRCurve) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"confidence", "precision", "recall"}); err != nil {
+		return err
+	}
+	for _, p := range c {
+		row := []string{
+			fmt.Sprintf("%v", p.Confidence),
+			fmt.Sprintf("%.6f", p.Precision),
+			fmt.Sprintf("%.6f", p.Recall),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,299 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fogleman/gg"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// a layer of a tile pyramid, drawn anew at each zoom level so that lines and
+// captions keep their size in pixels
+type PyramidLayer struct {
+	Name string
+	// the swatch of the layer in the viewer, none when nil
+	Color color.Color
+	// opaque layers are tiled in the format of the pyramid, and the others
+	// as transparent png, leaving out the tiles with nothing drawn on them
+	Opaque bool
+	// draws the layer on a context of the image scaled by scale
+	Draw func(dc *gg.Context, scale float64)
+}
+
+// the layout of a tile pyramid written by WritePyramid, as the viewer reads it.
+// Zoom level MaxZoom is the image at full size, and each level below it is
+// half the size of the one above, down to 0 which fits in a single tile.
+type Pyramid struct {
+	Title    string         `json:"title"`
+	Width    int            `json:"width"`
+	Height   int            `json:"height"`
+	TileSize int            `json:"tileSize"`
+	MaxZoom  int            `json:"maxZoom"`
+	Layers   []pyramidLayer `json:"layers"`
+}
+
+type pyramidLayer struct {
+	Name string `json:"name"`
+	// tiles are at <dir>/<z>/<x>/<y>.<ext>
+	Dir    string `json:"dir"`
+	Ext    string `json:"ext"`
+	Color  string `json:"color,omitempty"`
+	Opaque bool   `json:"opaque"`
+}
+
+// NewPyramid lays out a pyramid of an image of size in tiles of tileSize pixels
+func NewPyramid(title string, size image.Point, tileSize int) (Pyramid, error) {
+	if tileSize <= 0 {
+		return Pyramid{}, fmt.Errorf("tile size must be positive, not %d", tileSize)
+	}
+	p := Pyramid{Title: title, Width: size.X, Height: size.Y, TileSize: tileSize}
+	for tileSize<<uint(p.MaxZoom) < size.X || tileSize<<uint(p.MaxZoom) < size.Y {
+		p.MaxZoom++
+	}
+	return p, nil
+}
+
+// Scale of the image at zoom level z
+func (p Pyramid) Scale(z int) float64 {
+	return 1 / float64(int(1)<<uint(p.MaxZoom-z))
+}
+
+// WritePyramid writes the tiles of each layer at every zoom level to dir, in
+// format jpg or png for the opaque layers, and an index.html that pans and
+// zooms them and toggles the layers offline
+func WritePyramid(dir string, p Pyramid, layers []PyramidLayer, format string, quality int) error {
+	if format != "jpg" && format != "png" {
+		return fmt.Errorf("tile format must be jpg or png, not %q", format)
+	}
+	p.Layers = make([]pyramidLayer, len(layers))
+	for i, l := range layers {
+		pl := pyramidLayer{Name: l.Name, Dir: fmt.Sprint(i), Ext: "png", Opaque: l.Opaque}
+		if l.Opaque {
+			pl.Ext = format
+		}
+		if l.Color != nil {
+			pl.Color = cssColor(l.Color)
+		}
+		p.Layers[i] = pl
+	}
+
+	for z := 0; z <= p.MaxZoom; z++ {
+		s := p.Scale(z)
+		w, h := ceilScale(p.Width, s), ceilScale(p.Height, s)
+		for i, l := range layers {
+			dc := gg.NewContext(w, h)
+			l.Draw(dc, s)
+			if err := p.writeTiles(dir, p.Layers[i], z, dc.Image().(*image.RGBA), quality); err != nil {
+				return err
+			}
+		}
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	html := strings.Replace(pyramidViewer, "{{pyramid}}", string(b), 1)
+	return ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte(html), 0644)
+}
+
+// cuts a level of a layer into tiles
+func (p Pyramid) writeTiles(dir string, l pyramidLayer, z int, im *image.RGBA, quality int) error {
+	size := im.Bounds().Size()
+	for x := 0; x*p.TileSize < size.X; x++ {
+		xdir := filepath.Join(dir, l.Dir, fmt.Sprint(z), fmt.Sprint(x))
+		made := false
+		for y := 0; y*p.TileSize < size.Y; y++ {
+			r := image.Rect(x*p.TileSize, y*p.TileSize, (x+1)*p.TileSize, (y+1)*p.TileSize).Intersect(im.Bounds())
+			tile := im.SubImage(r).(*image.RGBA)
+			if !l.Opaque && transparent(tile) {
+				continue
+			}
+			if !made {
+				if err := os.MkdirAll(xdir, 0755); err != nil {
+					return err
+				}
+				made = true
+			}
+			if err := writeTile(filepath.Join(xdir, fmt.Sprintf("%d.%s", y, l.Ext)), tile, quality); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeTile(tileFile string, tile image.Image, quality int) error {
+	f, err := os.Create(tileFile)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(tileFile, ".jpg") {
+		err = jpeg.Encode(f, tile, &jpeg.Options{Quality: quality})
+	} else {
+		err = png.Encode(f, tile)
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %v", tileFile, err)
+	}
+	return f.Close()
+}
+
+func transparent(im *image.RGBA) bool {
+	r := im.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := im.Pix[im.PixOffset(r.Min.X, y):im.PixOffset(r.Max.X, y)]
+		for i := 3; i < len(row); i += 4 {
+			if row[i] != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func ceilScale(n int, s float64) int {
+	ret := int(float64(n)*s + .999999)
+	if ret < 1 {
+		return 1
+	}
+	return ret
+}
+
+// a viewer of the tiles next to it, with the pyramid in place of {{pyramid}}
+const pyramidViewer = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>viewer</title>
+<style>
+html, body { margin: 0; height: 100%; overflow: hidden; background: #222; font: 13px sans-serif; }
+#view { position: absolute; top: 0; right: 0; bottom: 0; left: 0; overflow: hidden; cursor: grab; }
+#view img { position: absolute; pointer-events: none; }
+#panel { position: absolute; top: 8px; right: 8px; z-index: 1000; padding: 6px 10px; border-radius: 4px; background: rgba(255, 255, 255, .9); }
+#panel b { display: block; margin-bottom: 4px; }
+#panel label { display: block; white-space: nowrap; }
+#panel .swatch { display: inline-block; width: 10px; height: 10px; margin-right: 4px; }
+#panel button { margin: 6px 2px 0 0; }
+</style>
+</head>
+<body>
+<div id="view"></div>
+<div id="panel"></div>
+<script>
+var P = {{pyramid}};
+var view = document.getElementById("view");
+var panel = document.getElementById("panel");
+// screen pixels per image pixel, and where the image origin is on screen
+var scale = 1, ox = 0, oy = 0;
+var shown = P.layers.map(function() { return true; });
+var tiles = {};
+
+function fit() {
+  scale = Math.min(view.clientWidth / P.width, view.clientHeight / P.height);
+  ox = (view.clientWidth - P.width * scale) / 2;
+  oy = (view.clientHeight - P.height * scale) / 2;
+  render();
+}
+
+function zoom(f, cx, cy) {
+  var min = Math.min(view.clientWidth / P.width, view.clientHeight / P.height) / 2;
+  var s = Math.max(min, Math.min(8, scale * f));
+  ox = cx - (cx - ox) * s / scale;
+  oy = cy - (cy - oy) * s / scale;
+  scale = s;
+  render();
+}
+
+function render() {
+  var z = Math.max(0, Math.min(P.maxZoom, Math.ceil(P.maxZoom + Math.log(scale) / Math.LN2 - 1e-9)));
+  var ls = Math.pow(2, z - P.maxZoom);
+  var lw = Math.ceil(P.width * ls), lh = Math.ceil(P.height * ls);
+  var k = scale / ls, t = P.tileSize;
+  var x0 = Math.max(0, Math.floor(-ox / k / t)), x1 = Math.min(Math.ceil(lw / t) - 1, Math.floor((view.clientWidth - ox) / k / t));
+  var y0 = Math.max(0, Math.floor(-oy / k / t)), y1 = Math.min(Math.ceil(lh / t) - 1, Math.floor((view.clientHeight - oy) / k / t));
+  var wanted = {};
+  P.layers.forEach(function(l, i) {
+    if (!shown[i]) return;
+    for (var x = x0; x <= x1; x++) {
+      for (var y = y0; y <= y1; y++) {
+        var src = l.dir + "/" + z + "/" + x + "/" + y + "." + l.ext;
+        var im = tiles[src];
+        if (!im) {
+          im = tiles[src] = document.createElement("img");
+          im.onerror = function() { this.style.visibility = "hidden"; };
+          im.src = src;
+          im.style.zIndex = i;
+        }
+        im.style.left = (ox + x * t * k) + "px";
+        im.style.top = (oy + y * t * k) + "px";
+        im.style.width = (Math.min(t, lw - x * t) * k) + "px";
+        im.style.height = (Math.min(t, lh - y * t) * k) + "px";
+        if (im.parentNode !== view) view.appendChild(im);
+        wanted[src] = true;
+      }
+    }
+  });
+  for (var src in tiles) {
+    if (!wanted[src] && tiles[src].parentNode === view) view.removeChild(tiles[src]);
+  }
+}
+
+document.title = P.title;
+var title = document.createElement("b");
+title.textContent = P.title;
+panel.appendChild(title);
+P.layers.forEach(function(l, i) {
+  var label = document.createElement("label");
+  var box = document.createElement("input");
+  box.type = "checkbox";
+  box.checked = true;
+  box.onchange = function() { shown[i] = box.checked; render(); };
+  label.appendChild(box);
+  if (l.color) {
+    var swatch = document.createElement("span");
+    swatch.className = "swatch";
+    swatch.style.background = l.color;
+    label.appendChild(swatch);
+  }
+  label.appendChild(document.createTextNode(l.name));
+  panel.appendChild(label);
+});
+[["+", function() { zoom(2, view.clientWidth / 2, view.clientHeight / 2); }],
+ ["-", function() { zoom(.5, view.clientWidth / 2, view.clientHeight / 2); }],
+ ["fit", fit],
+ ["1:1", function() { zoom(1 / scale, view.clientWidth / 2, view.clientHeight / 2); }]].forEach(function(b) {
+  var button = document.createElement("button");
+  button.textContent = b[0];
+  button.onclick = b[1];
+  panel.appendChild(button);
+});
+
+var drag = null;
+view.onmousedown = function(e) { drag = [e.clientX - ox, e.clientY - oy]; view.style.cursor = "grabbing"; };
+window.onmouseup = function() { drag = null; view.style.cursor = "grab"; };
+window.onmousemove = function(e) {
+  if (!drag) return;
+  ox = e.clientX - drag[0];
+  oy = e.clientY - drag[1];
+  render();
+};
+view.onwheel = function(e) {
+  e.preventDefault();
+  zoom(e.deltaY < 0 ? 1.25 : 0.8, e.clientX, e.clientY);
+};
+view.ondblclick = function(e) { zoom(2, e.clientX, e.clientY); };
+window.onresize = render;
+fit();
+</script>
+</body>
+</html>
+`
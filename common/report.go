@@ -0,0 +1,281 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// a metric that is encoded as null in json when it is undefined
+type Metric float64
+
+func (m Metric) MarshalJSON() ([]byte, error) {
+	f := float64(m)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+func (m *Metric) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*m = Metric(math.NaN())
+		return nil
+	}
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	*m = Metric(f)
+	return nil
+}
+
+// Format with prec decimals, or N/A when undefined
+func (m Metric) Format(prec int) string {
+	if math.IsNaN(float64(m)) {
+		return "N/A"
+	}
+	return strconv.FormatFloat(float64(m), 'f', prec, 64)
+}
+
+// a confidence interval
+type Interval struct {
+	Lo Metric `json:"lo"`
+	Hi Metric `json:"hi"`
+}
+
+func (i Interval) String() string {
+	return fmt.Sprintf("[%v,%v]", i.Lo.Format(3), i.Hi.Format(3))
+}
+
+type ClassIntervals struct {
+	Precision Interval `json:"precision"`
+	Recall    Interval `json:"recall"`
+	F1        Interval `json:"f1"`
+	AP        Interval `json:"ap"`
+	// bootstrap samples without truth of the class, where its metrics
+	// are undefined
+	Undefined int `json:"undefined_samples"`
+}
+
+// the scoring of a single class
+type ClassReport struct {
+	Class          CID             `json:"class"`
+	Name           string          `json:"name,omitempty"`
+	Truth          int             `json:"truth"`
+	TruePositives  int             `json:"true_positives"`
+	FalsePositives int             `json:"false_positives"`
+	TrueNegatives  int             `json:"true_negatives"`
+	FalseNegatives int             `json:"false_negatives"`
+	Precision      Metric          `json:"precision"`
+	Recall         Metric          `json:"recall"`
+	Specificity    Metric          `json:"specificity"`
+	F1             Metric          `json:"f1"`
+	AP             *Metric         `json:"ap,omitempty"`
+	CI             *ClassIntervals `json:"ci,omitempty"`
+}
+
+// the scoring of a run, for tables and machine-readable output
+type Report struct {
+	Classes          []ClassReport `json:"classes"`
+	FalsePositives   int           `json:"false_positives"`
+	Accuracy         Metric        `json:"accuracy"`
+	BalancedAccuracy Metric        `json:"balanced_accuracy"`
+	Kappa            Metric        `json:"kappa"`
+	MCC              Metric        `json:"mcc"`
+	MicroPrecision   Metric        `json:"micro_precision"`
+	MicroRecall      Metric        `json:"micro_recall"`
+	MicroF1          Metric        `json:"micro_f1"`
+	MacroPrecision   Metric        `json:"macro_precision"`
+	MacroRecall      Metric        `json:"macro_recall"`
+	MacroF1          Metric        `json:"macro_f1"`
+	WeightedF1       Metric        `json:"weighted_f1"`
+	MAP              *Metric       `json:"map,omitempty"`
+	MAPInterval      *Interval     `json:"map_ci,omitempty"`
+	CILevel          float64       `json:"ci_level,omitempty"`
+	Samples          int           `json:"bootstrap_samples,omitempty"`
+}
+
+// GetReport summarizes a ConfusionMatrix, along with the average precision
+// of each class when curves are given and class names when labels are given.
+// Undefined metrics are reported as NaN unless handled as zero by u.
+func GetReport(c ConfusionMatrix, curves map[CID]PRCurve, labels map[CID]string, u Undefined) Report {
+	m := func(v float64) Metric {
+		return Metric(u.Resolve(v))
+	}
+
+	classes := c.Classes()
+	ret := Report{
+		Classes:          make([]ClassReport, 0, len(classes)),
+		FalsePositives:   c[FP].T,
+		Accuracy:         m(GetAccuracy(c)),
+		BalancedAccuracy: m(GetBalancedAccuracy(c, u)),
+		Kappa:            m(GetKappa(c)),
+		MCC:              m(GetMCC(c)),
+		MicroPrecision:   m(GetMicroPrecision(c)),
+		MicroRecall:      m(GetMicroRecall(c)),
+		MicroF1:          m(GetMicroF1(c)),
+		MacroPrecision:   m(GetMacroPrecision(c, u)),
+		MacroRecall:      m(GetMacroRecall(c, u)),
+		MacroF1:          m(GetMacroF1(c, u)),
+		WeightedF1:       m(GetWeightedF1(c, u)),
+	}
+	for _, k := range classes {
+		cr := ClassReport{
+			Class:          k,
+			Name:           labels[k],
+			Truth:          c[k].T,
+			TruePositives:  int(GetTruePositives(k, c)),
+			FalsePositives: int(GetFalsePositives(k, c)),
+			TrueNegatives:  int(GetTrueNegatives(k, c)),
+			FalseNegatives: int(GetFalseNegatives(k, c)),
+			Precision:      m(GetPrecision(k, c)),
+			Recall:         m(GetRecall(k, c)),
+			Specificity:    m(GetSpecificity(k, c)),
+			F1:             m(GetF1Score(k, c)),
+		}
+		if curve, ok := curves[k]; ok {
+			ap := Metric(curve.AveragePrecision())
+			cr.AP = &ap
+		}
+		ret.Classes = append(ret.Classes, cr)
+	}
+	if curves != nil {
+		m := Metric(MeanAP(curves))
+		ret.MAP = &m
+	}
+	return ret
+}
+
+// SetIntervals attaches bootstrapped confidence intervals to the report
+func (r *Report) SetIntervals(ci map[CID]ClassIntervals, mapci Interval, level float64, samples int) {
+	for i := range r.Classes {
+		if c, ok := ci[r.Classes[i].Class]; ok {
+			c := c
+			r.Classes[i].CI = &c
+		}
+	}
+	r.MAPInterval = &mapci
+	r.CILevel = level
+	r.Samples = samples
+}
+
+// Summary returns a table of precision, recall, true positive,
+// false positive, and true negatives for each class
+func (r Report) Summary() string {
+	var buffer bytes.Buffer
+	w := new(tabwriter.Writer)
+	w.Init(&buffer, 0, 8, 1, '\t', 0)
+
+	named, withAP, withCI := false, r.MAP != nil, r.Samples > 0
+	for _, c := range r.Classes {
+		named = named || c.Name != ""
+	}
+
+	header := []string{"Reference Class"}
+	under := []string{"---------------"}
+	if named {
+		header, under = append(header, "Name"), append(under, "----")
+	}
+	header = append(header, "Truth", "True Positives", "False Positives", "True Negatives", "False Negatives", "Precision")
+	under = append(under, "-----", "--------------", "---------------", "--------------", "---------", "---------")
+	if withCI {
+		header, under = append(header, "CI"), append(under, "--")
+	}
+	header, under = append(header, "Recall"), append(under, "------")
+	if withCI {
+		header, under = append(header, "CI"), append(under, "--")
+	}
+	header, under = append(header, "Specificity"), append(under, "-----------")
+	header, under = append(header, "F1 Score"), append(under, "--------")
+	if withCI {
+		header, under = append(header, "CI"), append(under, "--")
+	}
+	if withAP {
+		header, under = append(header, "AP"), append(under, "--")
+		if withCI {
+			header, under = append(header, "CI"), append(under, "--")
+		}
+	}
+	if withCI {
+		header, under = append(header, "Undefined Samples"), append(under, "-----------------")
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	fmt.Fprintln(w, strings.Join(under, "\t"))
+
+	for _, c := range r.Classes {
+		row := []string{fmt.Sprint(c.Class)}
+		if named {
+			row = append(row, c.Name)
+		}
+		row = append(row,
+			fmt.Sprint(c.Truth),
+			fmt.Sprint(c.TruePositives),
+			fmt.Sprint(c.FalsePositives),
+			fmt.Sprint(c.TrueNegatives),
+			fmt.Sprint(c.FalseNegatives),
+			c.Precision.Format(3))
+		if withCI {
+			row = append(row, ci(c.CI, func(i *ClassIntervals) Interval { return i.Precision }))
+		}
+		row = append(row, c.Recall.Format(3))
+		if withCI {
+			row = append(row, ci(c.CI, func(i *ClassIntervals) Interval { return i.Recall }))
+		}
+		row = append(row, c.Specificity.Format(3))
+		row = append(row, c.F1.Format(4))
+		if withCI {
+			row = append(row, ci(c.CI, func(i *ClassIntervals) Interval { return i.F1 }))
+		}
+		if withAP {
+			ap := "-"
+			if c.AP != nil {
+				ap = c.AP.Format(4)
+			}
+			row = append(row, ap)
+			if withCI {
+				row = append(row, ci(c.CI, func(i *ClassIntervals) Interval { return i.AP }))
+			}
+		}
+		if withCI {
+			undefined := "-"
+			if c.CI != nil {
+				undefined = fmt.Sprint(c.CI.Undefined)
+			}
+			row = append(row, undefined)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+	buffer.WriteString(fmt.Sprintf("False Positives:  %v\n", r.FalsePositives))
+	buffer.WriteString(fmt.Sprintf("Overall accuracy: %v\n", r.Accuracy.Format(4)))
+	buffer.WriteString(fmt.Sprintf("Balanced accuracy: %v\n", r.BalancedAccuracy.Format(4)))
+	buffer.WriteString(fmt.Sprintf("Cohen's kappa:    %v\n", r.Kappa.Format(4)))
+	buffer.WriteString(fmt.Sprintf("MCC:              %v\n", r.MCC.Format(4)))
+	buffer.WriteString(fmt.Sprintf("Micro P/R/F1:     %v %v %v\n", r.MicroPrecision.Format(4), r.MicroRecall.Format(4), r.MicroF1.Format(4)))
+	buffer.WriteString(fmt.Sprintf("Macro P/R/F1:     %v %v %v\n", r.MacroPrecision.Format(4), r.MacroRecall.Format(4), r.MacroF1.Format(4)))
+	buffer.WriteString(fmt.Sprintf("Weighted F1:      %v\n", r.WeightedF1.Format(4)))
+	if withAP {
+		buffer.WriteString(fmt.Sprintf("mAP:              %v", r.MAP.Format(4)))
+		if withCI && r.MAPInterval != nil {
+			buffer.WriteString(fmt.Sprintf(" %v", *r.MAPInterval))
+		}
+		buffer.WriteString("\n")
+	}
+	if withCI {
+		buffer.WriteString(fmt.Sprintf("CI: %v%% over %v bootstrap samples\n", r.CILevel*100, r.Samples))
+	}
+
+	return buffer.String()
+}
+
+func ci(c *ClassIntervals, f func(*ClassIntervals) Interval) string {
+	if c == nil {
+		return "-"
+	}
+	return f(c).String()
+}
@@ -0,0 +1,275 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// the truth and detections of a single image
+type Scene struct {
+	Name    string
+	Truth   []Truth
+	Detects []Detect
+}
+
+// the outcome of a detection matched against truth of its class
+type Scored struct {
+	Class      CID     `json:"class"`
+	Confidence float32 `json:"confidence"`
+	TP         bool    `json:"tp"`
+	T          TID     `json:"truth,omitempty"`
+}
+
+// the class-aware matching of a scene, which can be combined
+// with the results of other scenes to build curves
+type SceneResult struct {
+	Name  string      `json:"name"`
+	Truth map[CID]int `json:"truth"`
+	// in order of decreasing confidence
+	Scored []Scored `json:"scored"`
+}
+
+// LoadScenes reads predictions and ground truth for one or more scenes.
+// When predictions is a dir each <scene>.txt in it is a scene, with truth
+// read from <scene>.geojson when groundtruth is a dir, or otherwise from the
+// features of groundtruth that have an image_id of the scene. mode is how
+// malformed prediction lines and truth features are handled.
+func LoadScenes(predictions, groundtruth string, mode ParseMode) ([]Scene, error) {
+	pinfo, err := os.Stat(predictions)
+	if err != nil {
+		return nil, err
+	}
+	if !pinfo.IsDir() {
+		detects, err := ReadPredictions(predictions, mode)
+		if err != nil {
+			return nil, err
+		}
+		truth, err := ReadTruth(groundtruth, mode)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", groundtruth, err)
+		}
+		_, name, _ := SplitPath(predictions)
+		return []Scene{{Name: name, Truth: truth, Detects: detects}}, nil
+	}
+
+	tinfo, err := os.Stat(groundtruth)
+	if err != nil {
+		return nil, err
+	}
+
+	// a single geojson is split up by image
+	var byImage map[string][]Truth
+	if !tinfo.IsDir() {
+		ref, err := ReadFeatures(groundtruth)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", groundtruth, err)
+		}
+		byImage = make(map[string][]Truth)
+		for i, f := range ref.Features {
+			t, ok, err := featureTruth(groundtruth, i, f, mode)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", groundtruth, err)
+			}
+			if ok {
+				_, name, _ := SplitPath(f.Properties.Image)
+				byImage[name] = append(byImage[name], t)
+			}
+		}
+	}
+
+	files, err := ioutil.ReadDir(predictions)
+	if err != nil {
+		return nil, err
+	}
+
+	scenes := make([]Scene, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".txt") {
+			continue
+		}
+		name := strings.TrimSuffix(file.Name(), ".txt")
+		pfile := filepath.Join(predictions, file.Name())
+		detects, err := ReadPredictions(pfile, mode)
+		if err != nil {
+			return nil, err
+		}
+
+		var truth []Truth
+		if byImage != nil {
+			truth = byImage[name]
+		} else {
+			tfile := filepath.Join(groundtruth, name+".geojson")
+			truth, err = ReadTruth(tfile, mode)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", tfile, err)
+			}
+		}
+		scenes = append(scenes, Scene{Name: name, Truth: truth, Detects: detects})
+	}
+	return scenes, nil
+}
+
+// ReadSceneTruth reads the truth of a scene, the features of a geojson with
+// an image_id of the scene, or all of them when none has an image_id, under
+// mode as ReadTruth. It fails when no feature has an image_id of the scene.
+func ReadSceneTruth(geojsonFile, name string, mode ParseMode) ([]Truth, error) {
+	ref, err := ReadFeatures(geojsonFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", geojsonFile, err)
+	}
+	ids := false
+	for _, f := range ref.Features {
+		ids = ids || f.Properties.Image != ""
+	}
+
+	truth := make([]Truth, 0)
+	found := 0
+	for i, f := range ref.Features {
+		if _, n, _ := SplitPath(f.Properties.Image); ids && n != name {
+			continue
+		}
+		found++
+		t, ok, err := featureTruth(geojsonFile, i, f, mode)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", geojsonFile, err)
+		}
+		if ok {
+			truth = append(truth, t)
+		}
+	}
+	if ids && found == 0 {
+		return nil, fmt.Errorf("%s: no features with an image_id of %s", geojsonFile, name)
+	}
+	return truth, nil
+}
+
+// DropIgnored matches the accepted detections to truth not marked Ignore
+// with MatchDetections, and then removes the detections left unmatched,
+// accepted or not, that overlap truth marked Ignore by at least minIou,
+// along with the ignored truth itself, so that they are neither true nor
+// false positives
+func (s Scene) DropIgnored(minIou float32, accept func(Detect) bool) Scene {
+	ret := Scene{Name: s.Name, Truth: make([]Truth, 0, len(s.Truth))}
+	ignored := make([]Truth, 0)
+	for _, t := range s.Truth {
+		if t.Ignore {
+			ignored = append(ignored, t)
+		} else {
+			ret.Truth = append(ret.Truth, t)
+		}
+	}
+	if len(ignored) == 0 {
+		return s
+	}
+
+	// the unmatched detections come back in the order given, so walk them
+	// alongside the accepted detections to find which matched
+	_, _, fds := MatchDetections(ret.Truth, s.Detects, minIou, accept)
+	ret.Detects = make([]Detect, 0, len(s.Detects))
+	index := NewTruthIndex(ignored)
+	k := 0
+	for _, d := range s.Detects {
+		if accept(d) {
+			if k >= len(fds) || fds[k] != d {
+				ret.Detects = append(ret.Detects, d)
+				continue
+			}
+			k++
+		}
+		drop := false
+		for _, j := range index.Query(d.Bounds) {
+			if iou := IoU(ignored[j].Bounds, d.Bounds); iou > 0 && iou >= minIou {
+				drop = true
+				break
+			}
+		}
+		if !drop {
+			ret.Detects = append(ret.Detects, d)
+		}
+	}
+	return ret
+}
+
+// ScoreScene matches the detections of a scene against its truth by class
+func ScoreScene(name string, truth []Truth, detects []Detect, minIou float32) SceneResult {
+	ret := SceneResult{
+		Name:   name,
+		Truth:  make(map[CID]int),
+		Scored: make([]Scored, 0, len(detects)),
+	}
+	for _, t := range truth {
+		ret.Truth[t.Class]++
+	}
+
+	sorted, matches := MatchByClass(truth, detects, minIou)
+	for i, d := range sorted {
+		s := Scored{Class: d.Class, Confidence: d.Confidence}
+		if matches[i] != nil {
+			s.TP = true
+			s.T = matches[i].Id
+		}
+		ret.Scored = append(ret.Scored, s)
+	}
+	return ret
+}
+
+// MergeSceneResults accumulates the results of scenes into one
+func MergeSceneResults(name string, results ...SceneResult) SceneResult {
+	ret := SceneResult{
+		Name:   name,
+		Truth:  make(map[CID]int),
+		Scored: make([]Scored, 0),
+	}
+	for _, r := range results {
+		for cid, cnt := range r.Truth {
+			ret.Truth[cid] += cnt
+		}
+		ret.Scored = append(ret.Scored, r.Scored...)
+	}
+	sortScored(ret.Scored)
+	return ret
+}
+
+// GetSceneCurves builds a precision-recall curve for each ground truth class
+// across the combined results of scenes
+func GetSceneCurves(results []SceneResult) map[CID]PRCurve {
+	truth := make(map[CID]int)
+	scored := make(map[CID][]Scored)
+	for _, r := range results {
+		for cid, cnt := range r.Truth {
+			truth[cid] += cnt
+		}
+		for _, s := range r.Scored {
+			scored[s.Class] = append(scored[s.Class], s)
+		}
+	}
+
+	ret := make(map[CID]PRCurve, len(truth))
+	for cid, cnt := range truth {
+		ss := scored[cid]
+		sortScored(ss)
+		ret[cid] = NewPRCurve(ss, cnt)
+	}
+	return ret
+}
+
+// GetOverallSceneCurve builds a single precision-recall curve across all
+// classes and the combined results of scenes
+func GetOverallSceneCurve(results []SceneResult) PRCurve {
+	merged := MergeSceneResults("", results...)
+	total := 0
+	for _, cnt := range merged.Truth {
+		total += cnt
+	}
+	return NewPRCurve(merged.Scored, total)
+}
+
+func sortScored(scored []Scored) {
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].Confidence > scored[j].Confidence
+	})
+}
@@ -0,0 +1,312 @@
+package common
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// a scene reviewed against its truth, written by WriteHTML as a single
+// offline page
+type SceneReport struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// the thumbnail of the scene as a data uri
+	Image      template.URL   `json:"-"`
+	IoU        float32        `json:"iou"`
+	Confidence float32        `json:"confidence"`
+	Classes    []reportClass  `json:"classes"`
+	Detects    []reportDetect `json:"detects"`
+	Truth      []reportTruth  `json:"truth"`
+	// score of the detections accepted at Confidence, or by thresholds
+	Report Report `json:"report"`
+}
+
+type reportClass struct {
+	Class CID    `json:"class"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+	// of the curve of the class when it has truth, as score reports it
+	AP *Metric `json:"ap,omitempty"`
+}
+
+// a detection, and whether it matched truth of its class as score matches
+// for precision-recall curves, which holds at any confidence threshold
+type reportDetect struct {
+	Class      CID     `json:"class"`
+	Confidence float32 `json:"confidence"`
+	Box        [4]int  `json:"box"`
+	TP         bool    `json:"tp"`
+}
+
+// truth, and the confidence of the detection it matched, or -1, so that it
+// is missed at any threshold above that
+type reportTruth struct {
+	Class CID     `json:"class"`
+	Box   [4]int  `json:"box"`
+	Match float32 `json:"match"`
+}
+
+// NewSceneReport scores a scene as score does, with the detections accepted
+// at minConf, or by accept when it is not nil, for the report table
+func NewSceneReport(s Scene, minIou, minConf float32, accept func(Detect) bool, labels map[CID]string) SceneReport {
+	if accept == nil {
+		accept = func(d Detect) bool { return d.Confidence >= minConf }
+	}
+	result := ScoreScene(s.Name, s.Truth, s.Detects, minIou)
+	curves := GetSceneCurves([]SceneResult{result})
+	r := SceneReport{
+		Name:       s.Name,
+		IoU:        minIou,
+		Confidence: minConf,
+		Detects:    make([]reportDetect, 0, len(s.Detects)),
+		Truth:      make([]reportTruth, len(s.Truth)),
+		Report:     GetReport(GetSceneConfusionMatrix(s, minIou, accept), curves, labels, UndefinedSkip),
+	}
+
+	index := make(map[TID]int, len(s.Truth))
+	seen := make(map[CID]bool)
+	for i, t := range s.Truth {
+		index[t.Id] = i
+		seen[t.Class] = true
+		r.Truth[i] = reportTruth{Class: t.Class, Box: xywh(t.Bounds), Match: -1}
+	}
+	sorted, matches := MatchByClass(s.Truth, s.Detects, minIou)
+	for i, d := range sorted {
+		seen[d.Class] = true
+		r.Detects = append(r.Detects, reportDetect{Class: d.Class, Confidence: d.Confidence, Box: xywh(d.Bounds), TP: matches[i] != nil})
+		if matches[i] != nil {
+			r.Truth[index[matches[i].Id]].Match = d.Confidence
+		}
+	}
+
+	keys := make([]int, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, int(k))
+	}
+	sort.Ints(keys)
+	for _, k := range keys {
+		c := CID(k)
+		rc := reportClass{Class: c, Name: LabelName(labels, c), Color: cssColor(ClassColor(c))}
+		if curve, ok := curves[c]; ok {
+			ap := Metric(curve.AveragePrecision())
+			rc.AP = &ap
+		}
+		r.Classes = append(r.Classes, rc)
+	}
+	return r
+}
+
+// SetImage embeds im, scaled down to at most maxSize pixels a side when
+// maxSize is positive, as a jpeg of quality
+func (r *SceneReport) SetImage(im image.Image, maxSize, quality int) error {
+	sz := im.Bounds().Size()
+	r.Width, r.Height = sz.X, sz.Y
+	uri, err := JPEGDataURI(im, maxSize, quality)
+	if err != nil {
+		return err
+	}
+	r.Image = template.URL(uri)
+	return nil
+}
+
+// WriteHTML writes the report as a page with no external assets
+func (r SceneReport) WriteHTML(w io.Writer) error {
+	return sceneReportTemplate.Execute(w, r)
+}
+
+func xywh(r image.Rectangle) [4]int {
+	return [4]int{r.Min.X, r.Min.Y, r.Dx(), r.Dy()}
+}
+
+func cssColor(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+}
+
+var sceneReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"metric": func(m Metric) string { return m.Format(3) },
+	"ap": func(m *Metric) string {
+		if m == nil {
+			return ""
+		}
+		return m.Format(3)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} review</title>
+<style>
+html, body { margin: 0; height: 100%; font: 13px sans-serif; }
+body { display: flex; }
+#view { flex: 1; background: #222; overflow: hidden; cursor: grab; }
+#view svg { width: 100%; height: 100%; display: block; }
+#side { width: 460px; padding: 8px 12px; overflow: auto; border-left: 1px solid #ccc; }
+h1 { font-size: 16px; margin: 4px 0 8px; }
+h2 { font-size: 14px; margin: 14px 0 6px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: 2px 4px; text-align: right; white-space: nowrap; }
+th:first-child, td:first-child, th:nth-child(2), td:nth-child(2) { text-align: left; }
+tr:nth-child(even) td { background: #f3f3f3; }
+.swatch { display: inline-block; width: 10px; height: 10px; margin-right: 4px; }
+rect { fill: none; stroke-width: 2; vector-effect: non-scaling-stroke; }
+rect.tp { stroke: limegreen; }
+rect.fp { stroke: red; }
+rect.missed { stroke: deepskyblue; stroke-dasharray: 4 3; }
+rect.found { stroke: limegreen; stroke-dasharray: 4 3; opacity: .6; }
+</style>
+</head>
+<body>
+<div id="view">
+<svg id="svg" viewBox="0 0 {{.Width}} {{.Height}}" preserveAspectRatio="xMidYMid meet">
+<image href="{{.Image}}" x="0" y="0" width="{{.Width}}" height="{{.Height}}" preserveAspectRatio="none"/>
+<g id="truth"></g>
+<g id="detects"></g>
+</svg>
+</div>
+<div id="side">
+<h1>{{.Name}}</h1>
+<div>
+<label>confidence <input id="conf" type="range" min="0" max="1" step="0.01" value="{{.Confidence}}"></label> <span id="confval"></span>
+</div>
+<div>
+<label><input id="showdetects" type="checkbox" checked> detections: <span class="swatch" style="background: limegreen"></span>true positive <span class="swatch" style="background: red"></span>false positive</label><br>
+<label><input id="showtruth" type="checkbox" checked> truth, dashed: <span class="swatch" style="background: deepskyblue"></span>missed <span class="swatch" style="background: limegreen"></span>found</label>
+</div>
+
+<h2>At the confidence threshold</h2>
+<table id="live">
+<tr><th><input id="allclasses" type="checkbox" checked title="all classes"></th><th>Class</th><th>Truth</th><th>TP</th><th>FP</th><th>FN</th><th>Precision</th><th>Recall</th><th>F1</th><th>AP</th></tr>
+</table>
+
+<h2>Score at {{.Confidence}} confidence and {{.IoU}} IoU</h2>
+<table>
+<tr><th>Class</th><th>Name</th><th>Truth</th><th>TP</th><th>FP</th><th>FN</th><th>Precision</th><th>Recall</th><th>F1</th><th>AP</th></tr>
+{{range .Report.Classes}}<tr><td>{{.Class}}</td><td>{{.Name}}</td><td>{{.Truth}}</td><td>{{.TruePositives}}</td><td>{{.FalsePositives}}</td><td>{{.FalseNegatives}}</td><td>{{metric .Precision}}</td><td>{{metric .Recall}}</td><td>{{metric .F1}}</td><td>{{ap .AP}}</td></tr>
+{{end}}</table>
+<p>
+false positives of no class: {{.Report.FalsePositives}}<br>
+micro precision {{metric .Report.MicroPrecision}}, recall {{metric .Report.MicroRecall}}, F1 {{metric .Report.MicroF1}}<br>
+macro precision {{metric .Report.MacroPrecision}}, recall {{metric .Report.MacroRecall}}, F1 {{metric .Report.MacroF1}}<br>
+{{with .Report.MAP}}mAP {{metric .}}{{end}}
+</p>
+</div>
+<script>
+var R = {{.}};
+var NS = "http://www.w3.org/2000/svg";
+var svg = document.getElementById("svg");
+var conf = document.getElementById("conf");
+var shownClass = {};
+var names = {};
+
+function rects(g, boxes) {
+  return boxes.map(function(b) {
+    var r = document.createElementNS(NS, "rect");
+    r.setAttribute("x", b.box[0]);
+    r.setAttribute("y", b.box[1]);
+    r.setAttribute("width", b.box[2]);
+    r.setAttribute("height", b.box[3]);
+    var title = document.createElementNS(NS, "title");
+    title.textContent = names[b.class] + (b.confidence === undefined ? "" : " " + b.confidence.toFixed(2));
+    r.appendChild(title);
+    g.appendChild(r);
+    return r;
+  });
+}
+
+function fixed(v) {
+  return isNaN(v) ? "" : v.toFixed(3);
+}
+
+function update() {
+  var th = parseFloat(conf.value);
+  document.getElementById("confval").textContent = th.toFixed(2);
+  var showDetects = document.getElementById("showdetects").checked;
+  var showTruth = document.getElementById("showtruth").checked;
+  var counts = {};
+  R.classes.forEach(function(c) { counts[c.class] = {truth: 0, tp: 0, fp: 0}; });
+  R.detects.forEach(function(d, i) {
+    var on = d.confidence >= th;
+    if (on) counts[d.class][d.tp ? "tp" : "fp"]++;
+    detectRects[i].setAttribute("class", d.tp ? "tp" : "fp");
+    detectRects[i].style.display = on && showDetects && shownClass[d.class] ? "" : "none";
+  });
+  R.truth.forEach(function(t, i) {
+    counts[t.class].truth++;
+    truthRects[i].setAttribute("class", t.match >= th ? "found" : "missed");
+    truthRects[i].style.display = showTruth && shownClass[t.class] ? "" : "none";
+  });
+  R.classes.forEach(function(c) {
+    var n = counts[c.class], p = n.tp / (n.tp + n.fp), r = n.tp / n.truth;
+    var cells = [n.truth, n.tp, n.fp, n.truth - n.tp, fixed(p), fixed(r), fixed(2 * p * r / (p + r)), c.ap == null ? "" : c.ap.toFixed(3)];
+    cells.forEach(function(v, j) { rows[c.class].cells[j + 2].textContent = v; });
+  });
+}
+
+var rows = {};
+var live = document.getElementById("live");
+R.classes.forEach(function(c) {
+  names[c.class] = c.name;
+  shownClass[c.class] = true;
+  var tr = live.insertRow();
+  var box = document.createElement("input");
+  box.type = "checkbox";
+  box.checked = true;
+  box.onchange = function() { shownClass[c.class] = box.checked; update(); };
+  tr.insertCell().appendChild(box);
+  var name = tr.insertCell();
+  var swatch = document.createElement("span");
+  swatch.className = "swatch";
+  swatch.style.background = c.color;
+  name.appendChild(swatch);
+  name.appendChild(document.createTextNode(c.name));
+  for (var j = 0; j < 8; j++) tr.insertCell();
+  rows[c.class] = tr;
+});
+document.getElementById("allclasses").onchange = function() {
+  var on = this.checked;
+  live.querySelectorAll("td input").forEach(function(b) { b.checked = on; });
+  R.classes.forEach(function(c) { shownClass[c.class] = on; });
+  update();
+};
+var truthRects = rects(document.getElementById("truth"), R.truth);
+var detectRects = rects(document.getElementById("detects"), R.detects);
+conf.oninput = update;
+document.getElementById("showdetects").onchange = update;
+document.getElementById("showtruth").onchange = update;
+update();
+
+// pan and zoom by moving the view box
+var vb = [0, 0, R.width, R.height], drag = null;
+function setView() { svg.setAttribute("viewBox", vb.join(" ")); }
+function toImage(e) {
+  var p = svg.createSVGPoint();
+  p.x = e.clientX;
+  p.y = e.clientY;
+  return p.matrixTransform(svg.getScreenCTM().inverse());
+}
+svg.onwheel = function(e) {
+  e.preventDefault();
+  var f = e.deltaY < 0 ? 0.8 : 1.25, p = toImage(e);
+  vb = [p.x - (p.x - vb[0]) * f, p.y - (p.y - vb[1]) * f, vb[2] * f, vb[3] * f];
+  setView();
+};
+svg.onmousedown = function(e) { drag = toImage(e); };
+window.onmouseup = function() { drag = null; };
+window.onmousemove = function(e) {
+  if (!drag) return;
+  var p = toImage(e);
+  vb[0] -= p.x - drag.x;
+  vb[1] -= p.y - drag.y;
+  setView();
+};
+svg.ondblclick = function() { vb = [0, 0, R.width, R.height]; setView(); };
+</script>
+</body>
+</html>
+`))
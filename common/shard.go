@@ -0,0 +1,102 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+)
+
+// the scores of a single scene
+type SceneScore struct {
+	Matrix ConfusionMatrix `json:"matrix"`
+	Result SceneResult     `json:"result"`
+}
+
+// the scores of a set of scenes along with how they were scored, which
+// can be written out and merged with other shards to score a dataset
+type Shard struct {
+	IoU        float32      `json:"iou"`
+	Confidence float32      `json:"confidence"`
+	Thresholds Thresholds   `json:"thresholds,omitempty"`
+	Scenes     []SceneScore `json:"scenes"`
+}
+
+// ReadShard reads a shard written by Write
+func ReadShard(shardFile string) (Shard, error) {
+	var s Shard
+	b, err := ioutil.ReadFile(shardFile)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, fmt.Errorf("%s: %v", shardFile, err)
+	}
+	return s, nil
+}
+
+// Write the shard as json
+func (s Shard) Write(shardFile string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(shardFile, b, 0644)
+}
+
+// Merge the scenes of another shard into this one, which must have been
+// scored the same way and share no scenes
+func (s *Shard) Merge(o Shard) error {
+	if len(s.Scenes) == 0 && s.IoU == 0 {
+		s.IoU, s.Confidence, s.Thresholds = o.IoU, o.Confidence, o.Thresholds
+	}
+	if s.IoU != o.IoU || s.Confidence != o.Confidence || !sameThresholds(s.Thresholds, o.Thresholds) {
+		return fmt.Errorf("shards scored with different iou or confidence thresholds")
+	}
+
+	names := make(map[string]bool, len(s.Scenes))
+	for _, sc := range s.Scenes {
+		names[sc.Result.Name] = true
+	}
+	for _, sc := range o.Scenes {
+		if names[sc.Result.Name] {
+			return fmt.Errorf("scene %s is in more than one shard", sc.Result.Name)
+		}
+		s.Scenes = append(s.Scenes, sc)
+	}
+	return nil
+}
+
+// Matrix accumulates the confusion matrices of every scene
+func (s Shard) Matrix() ConfusionMatrix {
+	ret := make(ConfusionMatrix)
+	for _, sc := range s.Scenes {
+		ret.Add(sc.Matrix)
+	}
+	return ret
+}
+
+// Matrices of each scene, in scene order
+func (s Shard) Matrices() []ConfusionMatrix {
+	ret := make([]ConfusionMatrix, len(s.Scenes))
+	for i, sc := range s.Scenes {
+		ret[i] = sc.Matrix
+	}
+	return ret
+}
+
+// Results of each scene, in scene order
+func (s Shard) Results() []SceneResult {
+	ret := make([]SceneResult, len(s.Scenes))
+	for i, sc := range s.Scenes {
+		ret[i] = sc.Result
+	}
+	return ret
+}
+
+func sameThresholds(a, b Thresholds) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,117 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/fogleman/gg"
+	"html"
+	"image"
+	"io"
+	"sort"
+)
+
+// an image with boxes drawn over it as vector shapes, so that they stay
+// crisp at any zoom and can be styled or post-processed by their attributes
+type SVG struct {
+	// href of the image, eg. a JPEGDataURI, stretched over Size
+	Image string
+	Size  image.Point
+	// outlined thinly under the boxes, eg. a debug grid, with captions
+	// drawn inside the top left corner
+	Grid   []Box
+	Boxes  []Box
+	Legend []LegendEntry
+	Mode   CaptionMode
+	// of captions, in pixels of the image
+	FontSize float64
+}
+
+// Write the svg, placing captions and the legend as DrawBoxes and DrawLegend
+// would in the font of dc
+func (s SVG) Write(w io.Writer, dc *gg.Context) error {
+	bw := bufio.NewWriter(w)
+	fh := dc.FontHeight()
+	fmt.Fprintf(bw, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n", s.Size.X, s.Size.Y, s.Size.X, s.Size.Y)
+	fmt.Fprintf(bw, "<style>rect.box, rect.grid { fill: none; } text { font-family: sans-serif; font-size: %gpx; dominant-baseline: central; }</style>\n", s.FontSize)
+	if s.Image != "" {
+		fmt.Fprintf(bw, "<image xlink:href=\"%s\" x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" preserveAspectRatio=\"none\"/>\n", html.EscapeString(s.Image), s.Size.X, s.Size.Y)
+	}
+
+	if len(s.Grid) > 0 {
+		fmt.Fprintf(bw, "<g id=\"grid\">\n")
+		for _, b := range s.Grid {
+			r := b.Bounds
+			fmt.Fprintf(bw, "<rect class=\"grid\" x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" stroke=\"%s\" stroke-width=\"0.5\"%s/>\n", r.Min.X, r.Min.Y, r.Dx(), r.Dy(), cssColor(b.Color), svgAttrs(b.Attrs))
+			if b.Caption != "" {
+				fmt.Fprintf(bw, "<text x=\"%d\" y=\"%.1f\" fill=\"%s\">%s</text>\n", r.Min.X+4, float64(r.Min.Y)+4+fh/2, cssColor(b.Color), html.EscapeString(b.Caption))
+			}
+		}
+		fmt.Fprintf(bw, "</g>\n")
+	}
+
+	fmt.Fprintf(bw, "<g id=\"boxes\">\n")
+	for _, b := range s.Boxes {
+		r := b.Bounds
+		dash := ""
+		if len(b.Dash) > 0 {
+			dash = " stroke-dasharray=\"" + floats(b.Dash) + "\""
+		}
+		fmt.Fprintf(bw, "<rect class=\"box\" x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" stroke=\"%s\" stroke-width=\"2\"%s%s>", r.Min.X, r.Min.Y, r.Dx(), r.Dy(), cssColor(b.Color), dash, svgAttrs(b.Attrs))
+		if b.Caption != "" {
+			fmt.Fprintf(bw, "<title>%s</title>", html.EscapeString(b.Caption))
+		}
+		fmt.Fprintf(bw, "</rect>\n")
+	}
+	fmt.Fprintf(bw, "</g>\n")
+
+	reserved := LegendBounds(dc, s.Legend)
+	fmt.Fprintf(bw, "<g id=\"captions\">\n")
+	for i, at := range PlaceCaptions(dc, s.Boxes, s.Mode, reserved) {
+		if at.Empty() {
+			continue
+		}
+		b := s.Boxes[i]
+		fmt.Fprintf(bw, "<g class=\"caption\"%s><rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\"/>", svgAttrs(b.Attrs), at.Min.X, at.Min.Y, at.Dx(), at.Dy(), cssColor(b.Color))
+		fmt.Fprintf(bw, "<text x=\"%d\" y=\"%.1f\" fill=\"%s\">%s</text></g>\n", at.Min.X+2, float64(at.Min.Y)+float64(at.Dy())/2, cssColor(TextColor(b.Color)), html.EscapeString(b.Caption))
+	}
+	fmt.Fprintf(bw, "</g>\n")
+
+	if len(s.Legend) > 0 {
+		lh, pad := legendLayout(dc)
+		fmt.Fprintf(bw, "<g id=\"legend\"><rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"white\" fill-opacity=\"0.85\"/>\n", reserved.Min.X, reserved.Min.Y, reserved.Dx(), reserved.Dy())
+		for i, e := range s.Legend {
+			y := pad + pad/2 + float64(i)*lh
+			fmt.Fprintf(bw, "<rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\" fill=\"%s\"/>", 2*pad, y+lh*.2, lh*.6, lh*.6, cssColor(e.Color))
+			fmt.Fprintf(bw, "<text x=\"%.1f\" y=\"%.1f\" fill=\"black\">%s</text>\n", 2*pad+lh, y+lh/2, html.EscapeString(e.Text))
+		}
+		fmt.Fprintf(bw, "</g>\n")
+	}
+	fmt.Fprintf(bw, "</svg>\n")
+	return bw.Flush()
+}
+
+// the attributes as data- attributes, in order of name
+func svgAttrs(attrs map[string]string) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ret := ""
+	for _, k := range keys {
+		ret += fmt.Sprintf(" data-%s=\"%s\"", k, html.EscapeString(attrs[k]))
+	}
+	return ret
+}
+
+func floats(fs []float64) string {
+	ret := ""
+	for i, f := range fs {
+		if i > 0 {
+			ret += " "
+		}
+		ret += fmt.Sprint(f)
+	}
+	return ret
+}
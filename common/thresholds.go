@@ -0,0 +1,116 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// per-class confidence cutoffs
+type Thresholds map[CID]float32
+
+// a confidence cutoff and the metrics it achieves
+type SweepResult struct {
+	Confidence float32
+	Precision  float64
+	Recall     float64
+	Score      float64
+}
+
+// ReadThresholds reads a thresholds file of `class confidence' lines,
+// blank lines and lines starting with # are ignored
+func ReadThresholds(thresholdsFile string) (Thresholds, error) {
+	file, err := os.Open(thresholdsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ret := make(Thresholds)
+	err = ReadLines(file, thresholdsFile, Strict, func(line string) error {
+		splits := Fields(line)
+		if len(splits) != 2 {
+			return fmt.Errorf("expected `class confidence'")
+		}
+		class, err := strconv.Atoi(splits[0])
+		if err != nil {
+			return err
+		}
+		conf, err := strconv.ParseFloat(splits[1], 32)
+		if err != nil {
+			return err
+		}
+		ret[CID(class)] = float32(conf)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Write the thresholds in class order in the format read by ReadThresholds
+func (t Thresholds) Write(w io.Writer) error {
+	keys := make([]int, 0, len(t))
+	for k := range t {
+		keys = append(keys, int(k))
+	}
+	sort.Ints(keys)
+	for _, k := range keys {
+		if _, err := fmt.Fprintf(w, "%v %v\n", k, t[CID(k)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get the cutoff for a class, or def when the class has none
+func (t Thresholds) Get(class CID, def float32) float32 {
+	if c, ok := t[class]; ok {
+		return c
+	}
+	return def
+}
+
+// At returns the precision and recall of the curve when accepting
+// detections with confidence of at least conf
+func (c PRCurve) At(conf float32) (float64, float64) {
+	i := sort.Search(len(c), func(i int) bool {
+		return c[i].Confidence < conf
+	})
+	if i == 0 {
+		return 0, 0
+	}
+	return c[i-1].Precision, c[i-1].Recall
+}
+
+// Sweep evaluates the F-beta score of the curve at each confidence from min
+// to max by step and returns the cutoff with the best score, ties going to
+// the lowest confidence
+func (c PRCurve) Sweep(min, max, step, beta float64) SweepResult {
+	best := SweepResult{Confidence: float32(min)}
+	n := int(math.Round((max - min) / step))
+	for i := 0; i <= n; i++ {
+		conf := min + float64(i)*step
+		p, r := c.At(float32(conf))
+		s := FBeta(p, r, beta)
+		if s > best.Score {
+			best = SweepResult{Confidence: float32(conf), Precision: p, Recall: r, Score: s}
+		}
+	}
+	return best
+}
+
+// FBeta is the weighted harmonic mean of precision and recall,
+// where recall is considered beta times as important as precision
+func FBeta(precision, recall, beta float64) float64 {
+	b2 := beta * beta
+	d := b2*precision + recall
+	if d == 0 || math.IsNaN(d) {
+		return 0
+	}
+	return (1 + b2) * precision * recall / d
+}
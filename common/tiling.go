@@ -0,0 +1,102 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+)
+
+// how chips are laid over the parts of an image that a whole stride of
+// chips does not reach
+const (
+	// leave the right and bottom edges out
+	EdgesDrop = "drop"
+	// add a last chip flush with the right and bottom edges
+	EdgesShift = "shift"
+)
+
+// how detect chips an image, written with -tiling to render what it did
+type Tiling struct {
+	Chip   int    `json:"chip"`
+	Stride int    `json:"stride"`
+	Edges  string `json:"edges"`
+	// the size of the image chipped, when written by detect
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
+// NewTiling validates the tiling of chips of chip pixels, stride pixels
+// apart or chip pixels when stride is 0
+func NewTiling(chip, stride int, edges string) (Tiling, error) {
+	if stride <= 0 {
+		stride = chip
+	}
+	t := Tiling{Chip: chip, Stride: stride, Edges: edges}
+	return t, t.validate()
+}
+
+func (t Tiling) validate() error {
+	if t.Chip <= 0 {
+		return fmt.Errorf("chip size must be positive, not %d", t.Chip)
+	}
+	if t.Stride <= 0 || t.Stride > t.Chip {
+		return fmt.Errorf("stride must be between 1 and the chip size %d, not %d", t.Chip, t.Stride)
+	}
+	if t.Edges != EdgesDrop && t.Edges != EdgesShift {
+		return fmt.Errorf("edges must be %s or %s, not %q", EdgesDrop, EdgesShift, t.Edges)
+	}
+	return nil
+}
+
+// ReadTiling reads a tiling written by Write
+func ReadTiling(tilingFile string) (Tiling, error) {
+	var t Tiling
+	b, err := ioutil.ReadFile(tilingFile)
+	if err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, fmt.Errorf("%s: %v", tilingFile, err)
+	}
+	if err := t.validate(); err != nil {
+		return t, fmt.Errorf("%s: %v", tilingFile, err)
+	}
+	return t, nil
+}
+
+// Write the tiling as json
+func (t Tiling) Write(tilingFile string) error {
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(tilingFile, b, 0644)
+}
+
+// Chips returns the bounds of each chip of an image, row by row
+func (t Tiling) Chips(bounds image.Rectangle) []image.Rectangle {
+	xs := t.starts(bounds.Dx())
+	ys := t.starts(bounds.Dy())
+	ret := make([]image.Rectangle, 0, len(xs)*len(ys))
+	for _, y := range ys {
+		for _, x := range xs {
+			min := bounds.Min.Add(image.Pt(x, y))
+			ret = append(ret, image.Rectangle{Min: min, Max: min.Add(image.Pt(t.Chip, t.Chip))})
+		}
+	}
+	return ret
+}
+
+// offsets of chips along a side of n pixels
+func (t Tiling) starts(n int) []int {
+	ret := make([]int, 0)
+	p := 0
+	for ; p+t.Chip <= n; p += t.Stride {
+		ret = append(ret, p)
+	}
+	if t.Edges == EdgesShift && n >= t.Chip && ret[len(ret)-1]+t.Chip < n {
+		ret = append(ret, n-t.Chip)
+	}
+	return ret
+}
@@ -0,0 +1,163 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+)
+
+// a kind of problem with a ground-truth feature
+type Problem string
+
+const (
+	MalformedBounds Problem = "malformed bounds"
+	InvertedBounds  Problem = "inverted bounds"
+	ZeroArea        Problem = "zero area"
+	OutsideImage    Problem = "outside image"
+	ClippedToImage  Problem = "partly outside image"
+	DuplicateId     Problem = "duplicate feature_id"
+	UnknownClass    Problem = "unknown type_id"
+)
+
+// the order problems are reported in
+var Problems = []Problem{MalformedBounds, InvertedBounds, ZeroArea, OutsideImage, ClippedToImage, DuplicateId, UnknownClass}
+
+// a problem with the feature at Index of a collection
+type Issue struct {
+	Index   int
+	Id      int
+	Image   string
+	Problem Problem
+	Detail  string
+}
+
+func (i Issue) String() string {
+	s := fmt.Sprintf("%s feature %d (#%d): %s", i.Image, i.Id, i.Index, i.Problem)
+	if i.Detail != "" {
+		s += ", " + i.Detail
+	}
+	return s
+}
+
+// the outcome of validating a FeatureCollection, with what cleaning it
+// would take
+type Validation struct {
+	Issues []Issue
+	// whether each feature is kept when cleaning
+	Keep []bool
+	// the bounds of each kept feature, canonicalized and clipped to its image
+	Bounds []image.Rectangle
+}
+
+// ValidateFeatures checks the bounds, ids, and classes of features. Classes
+// are only checked when labels are given, and bounds against the image only
+// when extent knows the size of the image.
+func ValidateFeatures(fc FeatureCollection, labels map[CID]string, extent func(image string) (image.Rectangle, bool)) Validation {
+	n := len(fc.Features)
+	ret := Validation{
+		Issues: make([]Issue, 0),
+		Keep:   make([]bool, n),
+		Bounds: make([]image.Rectangle, n),
+	}
+	seen := make(map[int]int)
+
+	for i, f := range fc.Features {
+		p := f.Properties
+		issue := func(problem Problem, detail string) {
+			ret.Issues = append(ret.Issues, Issue{Index: i, Id: p.Id, Image: p.Image, Problem: problem, Detail: detail})
+		}
+
+		r, err := ParseBounds(p.Bounds)
+		if err != nil {
+			issue(MalformedBounds, err.Error())
+			continue
+		}
+		if r.Min.X > r.Max.X || r.Min.Y > r.Max.Y {
+			issue(InvertedBounds, p.Bounds)
+			r = r.Canon()
+		}
+		if r.Empty() {
+			issue(ZeroArea, p.Bounds)
+			continue
+		}
+		if ext, ok := extent(p.Image); ok && !r.In(ext) {
+			clipped := r.Intersect(ext)
+			if clipped.Empty() {
+				issue(OutsideImage, fmt.Sprintf("%v not in %v", r, ext))
+				continue
+			}
+			issue(ClippedToImage, fmt.Sprintf("%v clipped to %v", r, clipped))
+			r = clipped
+		}
+		if first, ok := seen[p.Id]; ok {
+			issue(DuplicateId, fmt.Sprintf("first at #%d", first))
+			continue
+		}
+		seen[p.Id] = i
+		if len(labels) > 0 {
+			if _, ok := labels[CID(p.Class)]; !ok {
+				issue(UnknownClass, strconv.Itoa(p.Class))
+				continue
+			}
+		}
+		ret.Keep[i] = true
+		ret.Bounds[i] = r
+	}
+	return ret
+}
+
+// Counts of each problem
+func (v Validation) Counts() map[Problem]int {
+	ret := make(map[Problem]int)
+	for _, i := range v.Issues {
+		ret[i.Problem]++
+	}
+	return ret
+}
+
+// WriteClean writes the geojson src with only the kept features, and their
+// bounds_imcoords fixed, leaving every other member as is
+func (v Validation) WriteClean(w io.Writer, src []byte) error {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(src, &doc); err != nil {
+		return err
+	}
+	var features []map[string]json.RawMessage
+	if err := json.Unmarshal(doc["features"], &features); err != nil {
+		return err
+	}
+	if len(features) != len(v.Keep) {
+		return fmt.Errorf("validated %d features, not %d", len(v.Keep), len(features))
+	}
+
+	var err error
+	kept := make([]map[string]json.RawMessage, 0, len(features))
+	for i, f := range features {
+		if !v.Keep[i] {
+			continue
+		}
+		var props map[string]json.RawMessage
+		if err := json.Unmarshal(f["properties"], &props); err != nil {
+			return err
+		}
+		r := v.Bounds[i]
+		b, _ := json.Marshal(fmt.Sprintf("%d,%d,%d,%d", r.Min.X, r.Min.Y, r.Max.X, r.Max.Y))
+		props["bounds_imcoords"] = b
+		if f["properties"], err = json.Marshal(props); err != nil {
+			return err
+		}
+		kept = append(kept, f)
+	}
+
+	if doc["features"], err = json.Marshal(kept); err != nil {
+		return err
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -1,23 +1,39 @@
 package common
 
-import "strconv"
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
 
-// <object-class> <x_center> <y_center> <width> <height>
-func ReadYoloLabels(csv [][]string) []YoloLabel {
+// ReadYoloLabels reads `<object-class> <x_center> <y_center> <width> <height>'
+// lines, delimited by spaces, tabs or commas. name is the file r is read
+// from, for errors.
+func ReadYoloLabels(r io.Reader, name string, mode ParseMode) ([]YoloLabel, error) {
 	labels := make([]YoloLabel, 0)
-	for _, splits := range csv {
-		class, _ := strconv.Atoi(splits[0])
-		x, _ := strconv.ParseFloat(splits[1], 64)
-		y, _ := strconv.ParseFloat(splits[2], 64)
-		w, _ := strconv.ParseFloat(splits[3], 64)
-		h, _ := strconv.ParseFloat(splits[4], 64)
+	err := ReadLines(r, name, mode, func(line string) error {
+		splits := Fields(line)
+		if len(splits) != 5 {
+			return fmt.Errorf("expected class x_center y_center width height, got %d fields", len(splits))
+		}
+		class, err := strconv.Atoi(splits[0])
+		if err != nil {
+			return err
+		}
+		var v [4]float64
+		for i := range v {
+			if v[i], err = strconv.ParseFloat(splits[i+1], 64); err != nil {
+				return err
+			}
+		}
 		labels = append(labels, YoloLabel{
 			Class: CID(class),
-			X:     x,
-			Y:     y,
-			W:     w,
-			H:     h,
+			X:     v[0],
+			Y:     v[1],
+			W:     v[2],
+			H:     v[3],
 		})
-	}
-	return labels
+		return nil
+	})
+	return labels, err
 }
@@ -0,0 +1,335 @@
+package main
+
+import (
+	. "./common"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// metrics of one class for one run
+type runStats struct {
+	AP        float64
+	Precision float64
+	Recall    float64
+}
+
+// a truth object that one run found and the other did not
+type change struct {
+	Scene string
+	Id    TID
+	Class CID
+	Found bool
+}
+
+// score two prediction runs against the same ground truth and report what changed
+func main() {
+	aFile := flag.String("a", "", "Path to baseline predictions csv, or dir of <scene>.txt")
+	bFile := flag.String("b", "", "Path to candidate predictions csv, or dir of <scene>.txt")
+	tFile := flag.String("groundtruth", "", "Path to ground-truth geojson, or dir of <scene>.geojson")
+	minIou := flag.Float64("iou", .5, "IOU threshold")
+	minConf := flag.Float64("confidence", .5, "Confidence threshold")
+	tholdfile := flag.String("thresholds", "", "Path to per-class confidence thresholds, overriding -confidence")
+	cmapfile := flag.String("classmap", "", "Path to `class target' lines remapping classes of both runs and the ground truth, where target may be ignore for don't care")
+	labelfile := flag.String("labels", "labels.txt", "Path of a class mapping dict")
+	samples := flag.Int("bootstrap", 1000, "Number of bootstrap resamples of the scenes")
+	seed := flag.Int64("seed", 1, "Random seed for bootstrap resampling")
+	changefile := flag.String("changes", "", "Path to write csv of truth newly found or missed")
+	strict := flag.Bool("strict", false, "Fail on malformed lines of predictions and labels or ground truth features, rather than skipping them")
+
+	flag.Parse()
+	if *aFile == "" || *bFile == "" || *tFile == "" {
+		flag.Usage()
+		return
+	}
+
+	labels, err := ReadLabels(*labelfile, GetParseMode(*strict))
+	if err != nil {
+		if *strict {
+			log.Fatalf("%s: %v", *labelfile, err)
+		}
+		log.Printf("%s: %v", *labelfile, err)
+	}
+
+	thresholds := make(Thresholds)
+	if *tholdfile != "" {
+		thresholds, err = ReadThresholds(*tholdfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	conf := func(cid CID) float32 {
+		return thresholds.Get(cid, float32(*minConf))
+	}
+	accept := func(d Detect) bool {
+		return d.Confidence >= conf(d.Class)
+	}
+
+	aScenes, err := LoadScenes(*aFile, *tFile, GetParseMode(*strict))
+	if err != nil {
+		log.Fatal(err)
+	}
+	bScenes, err := LoadScenes(*bFile, *tFile, GetParseMode(*strict))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *cmapfile != "" {
+		cmap, err := ReadClassMap(*cmapfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		aScenes, bScenes = cmap.Apply(aScenes), cmap.Apply(bScenes)
+	}
+	ra, rb, truth := pairScenes(aScenes, bScenes, float32(*minIou), accept)
+	log.Printf("scenes: %v", len(ra))
+	if len(ra) < 2 && *samples > 0 {
+		log.Println("WARNING: bootstrap over a single scene is degenerate")
+	}
+
+	sa := runMetrics(ra)
+	sb := runMetrics(rb)
+	classes := make([]int, 0, len(sa)+len(sb))
+	for k := range sa {
+		classes = append(classes, int(k))
+	}
+	for k := range sb {
+		if _, ok := sa[k]; !ok {
+			classes = append(classes, int(k))
+		}
+	}
+	sort.Ints(classes)
+
+	// paired bootstrap of the differences
+	apd := make(map[CID][]float64)
+	pd := make(map[CID][]float64)
+	rd := make(map[CID][]float64)
+	mapd := make([]float64, 0, *samples)
+	rnd := rand.New(rand.NewSource(*seed))
+	for i := 0; i < *samples; i++ {
+		idx := Resample(rnd, len(ra))
+		xa := make([]SceneScore, len(idx))
+		xb := make([]SceneScore, len(idx))
+		for j, k := range idx {
+			xa[j], xb[j] = ra[k], rb[k]
+		}
+		ma := runMetrics(xa)
+		mb := runMetrics(xb)
+		// a class without truth in the sample is undefined there
+		for _, ik := range classes {
+			cid := CID(ik)
+			a, b := ma.get(cid), mb.get(cid)
+			apd[cid] = append(apd[cid], b.AP-a.AP)
+			pd[cid] = append(pd[cid], b.Precision-a.Precision)
+			rd[cid] = append(rd[cid], b.Recall-a.Recall)
+		}
+		mapd = append(mapd, meanAP(mb)-meanAP(ma))
+	}
+
+	changes := diffFound(ra, rb, truth, conf)
+	found := make(map[CID]int)
+	missed := make(map[CID]int)
+	for _, c := range changes {
+		if c.Found {
+			found[c.Class]++
+		} else {
+			missed[c.Class]++
+		}
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Class\tName\tAP A\tAP B\tdAP\tp\tPrecision A\tPrecision B\tdPrecision\tp\tRecall A\tRecall B\tdRecall\tp\tNewly Found\tNewly Missed")
+	fmt.Fprintln(w, "-----\t----\t----\t----\t---\t-\t-----------\t-----------\t----------\t-\t--------\t--------\t-------\t-\t-----------\t------------")
+	for _, ik := range classes {
+		k := CID(ik)
+		a, b := sa.get(k), sb.get(k)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			k, LabelName(labels, k),
+			Metric(a.AP).Format(4), Metric(b.AP).Format(4), delta(a.AP, b.AP, 4), pvalue(apd[k]),
+			Metric(a.Precision).Format(3), Metric(b.Precision).Format(3), delta(a.Precision, b.Precision, 3), pvalue(pd[k]),
+			Metric(a.Recall).Format(3), Metric(b.Recall).Format(3), delta(a.Recall, b.Recall, 3), pvalue(rd[k]),
+			found[k], missed[k])
+	}
+	w.Flush()
+	fmt.Printf("mAP A: %.4f  mAP B: %.4f  dmAP: %+.4f  p: %v\n", meanAP(sa), meanAP(sb), meanAP(sb)-meanAP(sa), pvalue(mapd))
+	fmt.Printf("Newly found: %v  Newly missed: %v\n", len(changes)-countMissed(changes), countMissed(changes))
+
+	if *changefile != "" {
+		if err := writeChanges(*changefile, changes); err != nil {
+			log.Fatal(err)
+		}
+		log.Println(fmt.Sprint("changes written to file://", *changefile))
+	}
+}
+
+// aligns the scenes of both runs by name and scores them as score does,
+// a scene missing from one run is scored as having no detections
+func pairScenes(a, b []Scene, minIou float32, accept func(Detect) bool) ([]SceneScore, []SceneScore, map[string][]Truth) {
+	as := make(map[string]Scene, len(a))
+	bs := make(map[string]Scene, len(b))
+	truth := make(map[string][]Truth)
+	names := make([]string, 0, len(a))
+	for _, s := range a {
+		as[s.Name] = s
+		truth[s.Name] = s.Truth
+		names = append(names, s.Name)
+	}
+	for _, s := range b {
+		bs[s.Name] = s
+		if _, ok := truth[s.Name]; !ok {
+			truth[s.Name] = s.Truth
+			names = append(names, s.Name)
+		}
+	}
+	sort.Strings(names)
+
+	score := func(s Scene) SceneScore {
+		return SceneScore{
+			Matrix: GetSceneConfusionMatrix(s, minIou, accept),
+			Result: ScoreScene(s.Name, s.Truth, s.Detects, minIou),
+		}
+	}
+	ra := make([]SceneScore, len(names))
+	rb := make([]SceneScore, len(names))
+	for i, n := range names {
+		sa := Scene{Name: n, Truth: truth[n], Detects: as[n].Detects}.DropIgnored(minIou, accept)
+		sb := Scene{Name: n, Truth: truth[n], Detects: bs[n].Detects}.DropIgnored(minIou, accept)
+		ra[i], rb[i] = score(sa), score(sb)
+		truth[n] = sa.Truth
+	}
+	return ra, rb, truth
+}
+
+// the metrics of each truth class of a run, the AP from its precision-recall
+// curve and the precision and recall at the confidence threshold from its
+// confusion matrix, as score reports them
+type classStats map[CID]runStats
+
+func runMetrics(scores []SceneScore) classStats {
+	results := make([]SceneResult, len(scores))
+	cms := make([]ConfusionMatrix, len(scores))
+	for i, s := range scores {
+		results[i], cms[i] = s.Result, s.Matrix
+	}
+	curves := GetSceneCurves(results)
+	c := MergeConfusionMatrices(cms...)
+
+	ret := make(classStats, len(curves))
+	for cid, curve := range curves {
+		s := ret.get(cid)
+		s.AP = curve.AveragePrecision()
+		ret[cid] = s
+	}
+	for _, cid := range c.Classes() {
+		s := ret.get(cid)
+		s.Precision, s.Recall = GetPrecision(cid, c), GetRecall(cid, c)
+		ret[cid] = s
+	}
+	return ret
+}
+
+// the metrics of a class, undefined when it has no truth
+func (m classStats) get(cid CID) runStats {
+	if s, ok := m[cid]; ok {
+		return s
+	}
+	return runStats{AP: math.NaN(), Precision: math.NaN(), Recall: math.NaN()}
+}
+
+func meanAP(stats classStats) float64 {
+	sum, n := 0.0, 0
+	for _, s := range stats {
+		if !math.IsNaN(s.AP) {
+			sum += s.AP
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// truth found at the confidence threshold by one run and not the other
+func diffFound(ra, rb []SceneScore, truth map[string][]Truth, conf func(CID) float32) []change {
+	found := func(r SceneResult) map[TID]bool {
+		ret := make(map[TID]bool)
+		for _, s := range r.Scored {
+			if s.TP && s.Confidence >= conf(s.Class) {
+				ret[s.T] = true
+			}
+		}
+		return ret
+	}
+
+	changes := make([]change, 0)
+	for i := range ra {
+		name := ra[i].Result.Name
+		fa, fb := found(ra[i].Result), found(rb[i].Result)
+		for _, t := range truth[name] {
+			if fa[t.Id] != fb[t.Id] {
+				changes = append(changes, change{Scene: name, Id: t.Id, Class: t.Class, Found: fb[t.Id]})
+			}
+		}
+	}
+	return changes
+}
+
+func countMissed(changes []change) int {
+	n := 0
+	for _, c := range changes {
+		if !c.Found {
+			n++
+		}
+	}
+	return n
+}
+
+// the p-value of the deltas of the samples where both runs are defined
+func pvalue(deltas []float64) string {
+	defined := make([]float64, 0, len(deltas))
+	for _, d := range deltas {
+		if !math.IsNaN(d) {
+			defined = append(defined, d)
+		}
+	}
+	if len(defined) == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.3f", PValue(defined))
+}
+
+// the signed change from a to b, N/A when either is undefined
+func delta(a, b float64, prec int) string {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return Metric(math.NaN()).Format(prec)
+	}
+	return fmt.Sprintf("%+.*f", prec, b-a)
+}
+
+func writeChanges(outfile string, changes []change) error {
+	f, err := os.Create(outfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cw := csv.NewWriter(f)
+	cw.Write([]string{"scene", "feature_id", "type_id", "change"})
+	for _, c := range changes {
+		what := "missed"
+		if c.Found {
+			what = "found"
+		}
+		cw.Write([]string{c.Scene, strconv.Itoa(int(c.Id)), strconv.Itoa(int(c.Class)), what})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	. "./common"
-	"bufio"
 	"bytes"
 	"flag"
 	"fmt"
@@ -15,8 +14,6 @@ import (
 	"log"
 	"os"
 	"sort"
-	"strconv"
-	"strings"
 )
 
 // Some constants specific to the pre-trained model at:
@@ -37,6 +34,14 @@ func main() {
 	debugmode := flag.Bool("debug", false, "Enable debug mode")
 	minbounds := flag.Float64("min", 0.0, "Minimum confidence to output (WARNING: Will impact ppc)")
 	chipsize := flag.Int("chip", 544, "Chip dimension")
+	stride := flag.Int("stride", 0, "Pixels between chips, less than -chip for overlapping chips; defaults to -chip")
+	edges := flag.String("edges", EdgesDrop, "Right and bottom edges a whole stride of chips does not reach; drop them, or shift a last chip flush with the edge")
+	tilingfile := flag.String("tiling", "", "Path to write the chip size, stride and edges used, for render -tiling")
+	tholdfile := flag.String("thresholds", "", "Path to per-class minimum confidence to output, eg. from score -sweep")
+	hierfile := flag.String("hierarchy", "", "Path to a class hierarchy, eg. hierarchy.txt, to output classes at -level")
+	level := flag.Int("level", 0, "Hierarchy level of output classes, 0 for the top")
+	strict := flag.Bool("strict", false, "Fail on malformed lines of labels, rather than skipping them")
+	nms := flag.Float64("nms", 0, "Suppress detections overlapping a more confident one of the same class by more than this IOU, 0 to disable")
 
 	flag.Parse()
 	if *modelfile == "" || *imagefile == "" || *labelfile == "" {
@@ -44,8 +49,11 @@ func main() {
 		return
 	}
 
+	tiling, err := NewTiling(*chipsize, *stride, *edges)
+	if err != nil {
+		log.Fatal(err)
+	}
 	chipW := *chipsize
-	chipH := *chipsize
 
 	model, err := ioutil.ReadFile(*modelfile)
 	if err != nil {
@@ -56,6 +64,18 @@ func main() {
 		log.Fatalf("%v", err)
 	}
 
+	thresholds := make(Thresholds)
+	if *tholdfile != "" {
+		thresholds, err = ReadThresholds(*tholdfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	// fail on a bad labels file before running the model
+	if _, err := ReadLabels(*labelfile, GetParseMode(*strict)); err != nil {
+		log.Fatalf("%s: %v", *labelfile, err)
+	}
+
 	//
 	// all files are open, fire up TF
 	//
@@ -70,29 +90,25 @@ func main() {
 	}
 	defer session.Close()
 
-	// width-number and height-number
-	// TODO;; this leaves an offset that is not included
-	wn := im.Bounds().Dx() / chipW
-	hn := im.Bounds().Dy() / chipH
-
-	chips := make([]Chip, wn*hn)
-	for i := 0; i < wn*hn; i++ {
-		x := i % wn
-		y := i / wn
-		w := x * chipW
-		h := y * chipH
-
-		chipBounds := image.Rect(w, h, w+chipW, h+chipH)
+	chipBounds := tiling.Chips(im.Bounds())
+	chips := make([]Chip, len(chipBounds))
+	for i, b := range chipBounds {
 		chip := im.(interface {
 			SubImage(r image.Rectangle) image.Image
-		}).SubImage(chipBounds)
+		}).SubImage(b)
 
 		if chipW != W {
 			scaled := image.NewRGBA(image.Rect(0, 0, W, H))
 			draw.BiLinear.Scale(scaled, scaled.Bounds(), chip, chip.Bounds(), draw.Over, nil)
 			chip = scaled
 		}
-		chips[i] = Chip{x, y, chip}
+		chips[i] = Chip{X: b.Min.X, Y: b.Min.Y, Im: chip}
+	}
+	if *tilingfile != "" {
+		tiling.Width, tiling.Height = im.Bounds().Dx(), im.Bounds().Dy()
+		if err := tiling.Write(*tilingfile); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	if *debugmode {
@@ -134,56 +150,45 @@ func main() {
 
 		for i, score := range scores {
 			class := classes[i]
-			bounds := transformBox(chip.X, chip.Y, boxes[i])
+			bounds := transformBox(image.Pt(chip.X, chip.Y), boxes[i], ratio)
 			detects = append(detects,
 				Detect{
-					Bounds:     ResizeRect(bounds, ratio),
+					Bounds:     bounds,
 					Class:      CID(class),
 					Chip:       &chip,
 					Confidence: score,
 				})
 		}
 	}
-	printDetections(detects, *labelfile, float32(*minbounds))
-}
-
-func transformBox(chipX, chipY int, box []float32) image.Rectangle {
-	//     chip pos   ->  world pos
-	mx := int(box[1]*W) + (chipX * W)
-	Mx := int(box[3]*W) + (chipX * W)
-	my := int(box[0]*H) + (chipY * H)
-	My := int(box[2]*H) + (chipY * H)
-
-	return image.Rectangle{
-		Min: image.Point{X: mx, Y: my},
-		Max: image.Point{X: Mx, Y: My},
-	}
-}
-
-func printDetections(detects []Detect, labelsFile string, min float32) {
-	file, err := os.Open(labelsFile)
-	if err != nil {
-		log.Fatal(err)
+	if *hierfile != "" {
+		hier, err := ReadHierarchy(*hierfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		detects = hier.RollUpDetects(detects, *level)
 	}
-	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	if err := scanner.Err(); err != nil {
-		log.Printf("ERROR: failed to read %s: %v", labelsFile, err)
+	if *nms > 0 {
+		detects = NMS(detects, float32(*nms))
 	}
+	printDetections(detects, float32(*minbounds), thresholds)
+}
 
-	labels := make(map[int]string)
-	for scanner.Scan() {
-		splits := strings.Split(scanner.Text(), ":")
-		id, _ := strconv.Atoi(splits[0])
-		labels[id] = splits[1]
+// chip pos -> world pos, from the model's W x H to the chip at origin
+func transformBox(origin image.Point, box []float32, ratio float32) image.Rectangle {
+	r := image.Rectangle{
+		Min: image.Point{X: int(box[1] * W), Y: int(box[0] * H)},
+		Max: image.Point{X: int(box[3] * W), Y: int(box[2] * H)},
 	}
+	return ResizeRect(r, ratio).Add(origin)
+}
 
+func printDetections(detects []Detect, min float32, thresholds Thresholds) {
 	sort.SliceStable(detects, func(i, j int) bool {
 		return detects[i].Confidence > detects[j].Confidence
 	})
 	for _, d := range detects {
 		// squeeze is default; eliminating the 0 entries that inflate ppc
-		if d.Confidence > min {
+		if d.Confidence > min && d.Confidence >= thresholds.Get(d.Class, min) {
 			fmt.Printf("%v %v %v %v %v %v\n", d.Bounds.Min.X, d.Bounds.Min.Y, d.Bounds.Max.X, d.Bounds.Max.Y, d.Class, d.Confidence)
 		}
 	}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	. "./common"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// a box to crop, with the class whose sheet it goes on and the order in it
+type galleryItem struct {
+	Class      CID
+	Confidence float32
+	Box        Box
+}
+
+// crop detections out of a scene onto a contact sheet per class
+func main() {
+	imagefile := flag.String("image", "", "Path of the scene to crop detections from")
+	pFile := flag.String("predictions", "-", "Path to predictions csv, or - for stdin")
+	minConf := flag.Float64("confidence", .5, "Confidence threshold")
+	labelfile := flag.String("labels", "labels.txt", "Path of a class mapping dict")
+	outdir := flag.String("outdir", os.Getenv("PWD"), "Dir to write the contact sheets to")
+	strict := flag.Bool("strict", false, "Fail on malformed lines of predictions or ground truth features, rather than skipping them")
+	tFile := flag.String("groundtruth", "", "Path to ground-truth geojson, to outline crops by outcome as render reviews them and include missed truth")
+	minIou := flag.Float64("iou", .5, "IOU threshold of review")
+	tholdfile := flag.String("thresholds", "", "Path to per-class confidence thresholds of review, overriding -confidence")
+	cmapfile := flag.String("classmap", "", "Path to `class target' lines remapping classes of predictions and ground truth, where target may be ignore for don't care")
+	only := flag.String("only", "", "Comma separated outcomes to crop with -groundtruth; tp, fp, misclassified, missed")
+	top := flag.Int("top", 0, "Crop only the N most confident of each class")
+	sample := flag.Int("sample", 0, "Crop a random sample of N of each class")
+	seed := flag.Int64("seed", 1, "Seed of -sample")
+	pad := flag.Int("pad", 8, "Pixels of the scene around each box to crop")
+	cell := flag.Int("cell", 128, "Size each crop is scaled to fit")
+	cols := flag.Int("cols", 8, "Crops per row")
+	pagesize := flag.Int("page", 64, "Crops per sheet, or 0 for a single sheet of each class")
+	fontsize := flag.Float64("font-size", 10, "Caption font size in points")
+	quality := flag.Int("quality", 90, "JPEG quality of the sheets")
+
+	flag.Parse()
+	if *pFile == "" || *imagefile == "" {
+		flag.Usage()
+		return
+	}
+	if *top > 0 && *sample > 0 {
+		log.Fatal("-top and -sample are exclusive")
+	}
+	if *only != "" && *tFile == "" {
+		log.Fatal("-only needs -groundtruth to review against")
+	}
+	if err := os.MkdirAll(*outdir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	labels, err := ReadLabels(*labelfile, GetParseMode(*strict))
+	if err != nil {
+		if *strict {
+			log.Fatalf("%s: %v", *labelfile, err)
+		}
+		log.Printf("%s: %v", *labelfile, err)
+	}
+	im, err := LoadJpeg(*imagefile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	detects, err := ReadPredictions(*pFile, GetParseMode(*strict))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var cmap ClassMap
+	if *cmapfile != "" {
+		cmap, err = ReadClassMap(*cmapfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	detects = cmap.MapDetects(detects)
+	_, name, _ := SplitPath(*imagefile)
+
+	var items []galleryItem
+	if *tFile != "" {
+		keep := make(map[Outcome]bool)
+		for _, o := range Outcomes {
+			keep[o] = *only == ""
+		}
+		if *only != "" {
+			for _, s := range strings.Split(*only, ",") {
+				o, err := ParseOutcome(strings.TrimSpace(s))
+				if err != nil {
+					log.Fatal(err)
+				}
+				keep[o] = true
+			}
+		}
+		truth, err := ReadSceneTruth(*tFile, name, GetParseMode(*strict))
+		if err != nil {
+			log.Fatal(err)
+		}
+		truth = cmap.MapTruth(truth)
+		thresholds := make(Thresholds)
+		if *tholdfile != "" {
+			thresholds, err = ReadThresholds(*tholdfile)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		accept := func(d Detect) bool {
+			return d.Confidence >= thresholds.Get(d.Class, float32(*minConf))
+		}
+		s := Scene{Name: name, Truth: truth, Detects: detects}.DropIgnored(float32(*minIou), accept)
+		items = reviewItems(ReviewScene(s, float32(*minIou), accept), keep, labels)
+	} else {
+		for _, d := range detects {
+			if d.Confidence >= float32(*minConf) {
+				items = append(items, galleryItem{
+					Class:      d.Class,
+					Confidence: d.Confidence,
+					Box:        Box{Bounds: d.Bounds, Color: ClassColor(d.Class), Caption: fmt.Sprintf("%.2f", d.Confidence)},
+				})
+			}
+		}
+	}
+
+	byClass := make(map[CID][]galleryItem)
+	for _, it := range items {
+		byClass[it.Class] = append(byClass[it.Class], it)
+	}
+	classes := make([]int, 0, len(byClass))
+	for c := range byClass {
+		classes = append(classes, int(c))
+	}
+	sort.Ints(classes)
+
+	rnd := rand.New(rand.NewSource(*seed))
+	sheet := Sheet{Pad: *pad, Cell: *cell, Cols: *cols}
+	face := FontFace(*fontsize)
+	for _, c := range classes {
+		its := byClass[CID(c)]
+		sort.SliceStable(its, func(i, j int) bool {
+			return its[i].Confidence > its[j].Confidence
+		})
+		total := len(its)
+		if *top > 0 && len(its) > *top {
+			its = its[:*top]
+		}
+		if *sample > 0 && len(its) > *sample {
+			picked := rnd.Perm(len(its))[:*sample]
+			sort.Ints(picked)
+			sampled := make([]galleryItem, len(picked))
+			for i, p := range picked {
+				sampled[i] = its[p]
+			}
+			its = sampled
+		}
+
+		pages := Pages(len(its), *pagesize)
+		for p, r := range pages {
+			page := its[r[0]:r[1]]
+			boxes := make([]Box, len(page))
+			for i, it := range page {
+				boxes[i] = it.Box
+			}
+			title := fmt.Sprintf("%s %s (%d of %d), page %d of %d", name, LabelName(labels, CID(c)), len(its), total, p+1, len(pages))
+			output := filepath.Join(*outdir, fmt.Sprintf("%s-gallery-%d-%d.jpg", name, c, p+1))
+			if err := writeJPEG(output, sheet.ContactSheet(im, boxes, title, face), *quality); err != nil {
+				log.Fatal(err)
+			}
+			log.Println(fmt.Sprint("gallery written to file://", output))
+		}
+	}
+}
+
+// the crops of the kept outcomes of review, on the sheet of the class
+// detected, or of the truth when missed
+func reviewItems(reviewed []Reviewed, keep map[Outcome]bool, labels map[CID]string) []galleryItem {
+	ret := make([]galleryItem, 0, len(reviewed))
+	for _, r := range reviewed {
+		if !keep[r.Outcome] {
+			continue
+		}
+		it := galleryItem{Class: r.D.Class, Confidence: r.D.Confidence, Box: Box{Bounds: r.D.Bounds, Color: r.Outcome.Color()}}
+		switch r.Outcome {
+		case OutcomeMissed:
+			it.Class, it.Box.Bounds = r.T.Class, r.T.Bounds
+			it.Box.Caption = "missed"
+			it.Box.Dash = []float64{4, 3}
+		case OutcomeMisclassified:
+			it.Box.Caption = fmt.Sprintf("is %s %.2f", LabelName(labels, r.T.Class), r.D.Confidence)
+		default:
+			it.Box.Caption = fmt.Sprintf("%s %.2f", r.Outcome, r.D.Confidence)
+		}
+		ret = append(ret, it)
+	}
+	return ret
+}
+
+func writeJPEG(output string, im image.Image, quality int) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(f, im, &jpeg.Options{Quality: quality}); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %v", output, err)
+	}
+	return f.Close()
+}
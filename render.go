@@ -2,15 +2,22 @@ package main
 
 import (
 	. "./common"
-	"encoding/csv"
 	"flag"
 	"fmt"
 	"github.com/fogleman/gg"
 	"golang.org/x/image/colornames"
+	xdraw "golang.org/x/image/draw"
 	"image"
-	"image/draw"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 func main() {
@@ -19,10 +26,36 @@ func main() {
 	minConf := flag.Float64("confidence", .5, "Confidence threshold")
 	debugmode := flag.Bool("debug", false, "Enable debug mode")
 	outdir := flag.String("outdir", os.Getenv("PWD"), "Dir to write rendered image file")
-
-	const (
-		H, W = 544, 544
-	)
+	strict := flag.Bool("strict", false, "Fail on malformed lines of predictions or ground truth features, rather than skipping them")
+	labelfile := flag.String("labels", "labels.txt", "Path of a class mapping dict")
+	fontsize := flag.Float64("font-size", 12, "Caption font size in points")
+	caption := flag.String("caption", "auto", "Caption placement; auto to avoid overlapping captions, above, inside, or none")
+	legend := flag.Bool("legend", true, "Draw a legend of the classes rendered and their counts")
+	tFile := flag.String("groundtruth", "", "Path to ground-truth geojson, to review predictions against it as score matches them")
+	minIou := flag.Float64("iou", .5, "IOU threshold of review")
+	tholdfile := flag.String("thresholds", "", "Path to per-class confidence thresholds of review, overriding -confidence")
+	cmapfile := flag.String("classmap", "", "Path to `class target' lines remapping classes of predictions and ground truth, where target may be ignore for don't care")
+	chipsize := flag.Int("chip", 544, "Chip dimension of the debug grid, as given to detect")
+	stride := flag.Int("stride", 0, "Pixels between chips of the debug grid, as given to detect; defaults to -chip")
+	edges := flag.String("edges", EdgesDrop, "Edge handling of the debug grid, as given to detect; drop or shift")
+	tilingfile := flag.String("tiling", "", "Path to the tiling written by detect -tiling, overriding -chip, -stride and -edges")
+	chiplabels := flag.Bool("chip-labels", false, "Label each chip of the debug grid with its index and detection count")
+	quality := flag.Int("quality", 75, "JPEG quality of the rendered image or jpg tiles")
+	tiledir := flag.String("tiles", "", "Dir to write a zoomable tile pyramid and an index.html viewer of it to, instead of a single image")
+	tilesize := flag.Int("tile-size", 256, "Tile dimension of the pyramid")
+	tileformat := flag.String("tile-format", "jpg", "Format of the image tiles of the pyramid; jpg or png")
+	report := flag.Bool("report", false, "Write a self-contained html review of the scene against -groundtruth, instead of an image")
+	thumbsize := flag.Int("thumb", 2048, "Largest dimension of the scene embedded in the html review or svg, or of sweep frames, or 0 for full size")
+	sweep := flag.String("sweep", "", "Render a frame at each confidence of start:stop:step, eg. .1:.9:.1, as an animated gif")
+	framedir := flag.String("frames", "", "Dir to write the frames of -sweep to as images, instead of a gif")
+	delay := flag.Int("delay", 800, "Milliseconds each frame of -sweep is shown")
+	heatmap := flag.Bool("heatmap", false, "Render a density heatmap of the detections over the image, instead of their boxes")
+	classlist := flag.String("classes", "", "Comma separated classes of the heatmap, or all when empty")
+	sigma := flag.Float64("sigma", 32, "Pixels of the gaussian kernel of the heatmap, or 0 to count detections per cell")
+	densitycell := flag.Int("density-cell", 8, "Pixels of a cell of the density grid")
+	opacity := flag.Float64("opacity", .6, "Opacity of the densest part of the heatmap")
+	densityfile := flag.String("density", "", "Path to also write the density grid of the heatmap to, as csv or, for .tif, a float32 GeoTIFF georeferenced like -image when it is one")
+	format := flag.String("format", "jpg", "Format of the rendered image; jpg, or svg to draw boxes as vectors with data attributes over the embedded scene")
 
 	flag.Parse()
 	if *pFile == "" || *imagefile == "" {
@@ -30,59 +63,595 @@ func main() {
 		return
 	}
 
+	mode, err := ParseCaptionMode(*caption)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *format != "jpg" && *format != "svg" {
+		log.Fatalf("format must be jpg or svg, not %q", *format)
+	}
+	outputs := make([]string, 0)
+	for name, set := range map[string]bool{"-heatmap": *heatmap, "-report": *report, "-tiles": *tiledir != "", "-sweep": *sweep != ""} {
+		if set {
+			outputs = append(outputs, name)
+		}
+	}
+	sort.Strings(outputs)
+	if len(outputs) > 1 {
+		log.Fatalf("%s are exclusive, render one at a time", strings.Join(outputs, " and "))
+	}
+	if len(outputs) == 1 && *format != "jpg" {
+		log.Fatalf("-format %s only applies to a single rendered image, not %s", *format, outputs[0])
+	}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, need := range []struct {
+		flags []string
+		have  bool
+		what  string
+	}{
+		{[]string{"iou", "thresholds"}, *tFile != "", "-groundtruth"},
+		{[]string{"chip", "stride", "edges", "tiling", "chip-labels"}, *debugmode, "-debug"},
+		{[]string{"tile-size", "tile-format"}, *tiledir != "", "-tiles"},
+		{[]string{"delay"}, *sweep != "", "-sweep"},
+		{[]string{"classes", "sigma", "density-cell", "opacity", "density"}, *heatmap, "-heatmap"},
+	} {
+		for _, name := range need.flags {
+			if set[name] && !need.have {
+				log.Fatalf("-%s needs %s", name, need.what)
+			}
+		}
+	}
+	// boxes, their captions, the legend and the debug grid are not drawn
+	// by every output
+	for _, output := range []struct {
+		name    string
+		on      bool
+		ignored []string
+	}{
+		{"-heatmap", *heatmap, []string{"debug", "groundtruth", "legend", "caption"}},
+		{"-report", *report, []string{"debug", "legend", "caption"}},
+		{"-tiles", *tiledir != "", []string{"legend"}},
+	} {
+		for _, name := range output.ignored {
+			if output.on && set[name] {
+				log.Fatalf("-%s does not apply to %s", name, output.name)
+			}
+		}
+	}
+	if *report && *tFile == "" {
+		log.Fatal("-report needs -groundtruth to review against")
+	}
+	if *framedir != "" && *sweep == "" {
+		log.Fatal("-frames needs -sweep")
+	}
+	if *sweep != "" && *tholdfile != "" {
+		log.Fatal("-sweep varies one confidence for every class, it cannot be combined with -thresholds")
+	}
+	labels, err := ReadLabels(*labelfile, GetParseMode(*strict))
+	if err != nil {
+		if *strict {
+			log.Fatalf("%s: %v", *labelfile, err)
+		}
+		log.Printf("%s: %v", *labelfile, err)
+	}
+
 	im, err := LoadJpeg(*imagefile)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
 
-	var f *os.File
-	if *pFile == "-" {
-		f = os.Stdin
-	} else {
-		f, err = os.Open(*pFile)
+	detects, err := ReadPredictions(*pFile, GetParseMode(*strict))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var cmap ClassMap
+	if *cmapfile != "" {
+		cmap, err = ReadClassMap(*cmapfile)
 		if err != nil {
-			log.Fatalf("%s: %v\n", *pFile, err)
+			log.Fatal(err)
 		}
 	}
-
-	csvr := csv.NewReader(f)
-	csvr.Comma = ' '
-	predictions, _ := csvr.ReadAll()
-	detects := ReadDetects(predictions)
-	log.Println("detections: ", len(predictions))
-
-	rendered := image.NewRGBA(im.Bounds())
-	draw.Draw(rendered, im.Bounds(), im, image.ZP, draw.Src)
+	detects = cmap.MapDetects(detects)
+	log.Println("detections: ", len(detects))
 
 	sz := im.Bounds().Size()
-	dc := gg.NewContext(sz.X, sz.Y)
-	dc.DrawImage(im, 0, 0)
+	if *heatmap {
+		classes, err := parseClasses(*classlist)
+		if err != nil {
+			log.Fatal(err)
+		}
+		selected := make([]Detect, 0, len(detects))
+		for _, d := range detects {
+			if d.Confidence >= float32(*minConf) && (classes == nil || classes[d.Class]) {
+				selected = append(selected, d)
+			}
+		}
+		if *densitycell < 1 {
+			log.Fatalf("density cell must be positive, not %d", *densitycell)
+		}
+		density := NewDensity(sz, *densitycell, *sigma, selected)
+		if *densityfile != "" {
+			if err := writeDensity(*densityfile, density, *imagefile); err != nil {
+				log.Fatal(err)
+			}
+			log.Println(fmt.Sprint("density written to file://", *densityfile))
+		}
 
+		dc := gg.NewContext(sz.X, sz.Y)
+		dc.DrawImage(im, 0, 0)
+		max := density.Max()
+		dc.DrawImage(density.Overlay(sz, max, *opacity), 0, 0)
+		dc.SetFontFace(FontFace(*fontsize))
+		DrawColorBar(dc, max, fmt.Sprintf("detections per %dpx cell, of %d", *densitycell, len(selected)))
+
+		_, ofile, _ := SplitPath(*imagefile)
+		output := fmt.Sprintf("%s/%s-heatmap.jpg", *outdir, ofile)
+		if err := dc.SaveJPG(output, *quality); err != nil {
+			log.Fatalf("%s: %v\n", output, err)
+		}
+		log.Println(fmt.Sprint("rendered to file://", output))
+		return
+	}
+	// the chips of the debug grid, with detections counted at -confidence
+	// or at the confidence of each sweep frame
+	var chips []image.Rectangle
 	if *debugmode {
-		dc.SetLineWidth(.5)
-		dc.SetColor(colornames.Yellow)
-		for x := 0; x < sz.X/W; x++ {
-			for y := 0; y < sz.Y/H; y++ {
-				dc.DrawRectangle(float64(x*W), float64(y*H), float64(x*W+W), float64(y*H+H))
-				dc.Stroke()
+		tiling, err := NewTiling(*chipsize, *stride, *edges)
+		if *tilingfile != "" {
+			tiling, err = ReadTiling(*tilingfile)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if tiling.Width != 0 && (tiling.Width != sz.X || tiling.Height != sz.Y) {
+			log.Printf("WARNING: %s was tiled at %vx%v, not %vx%v", *imagefile, tiling.Width, tiling.Height, sz.X, sz.Y)
+		}
+		chips = tiling.Chips(im.Bounds())
+	}
+	grid := chipBoxes(chips, detects, float32(*minConf), *chiplabels)
+
+	// the boxes of the detections accepted at a confidence and their layers
+	var boxesAt func(min float32) ([]Box, []BoxLayer)
+	if *tFile != "" {
+		_, name, _ := SplitPath(*imagefile)
+		truth, err := ReadSceneTruth(*tFile, name, GetParseMode(*strict))
+		if err != nil {
+			log.Fatal(err)
+		}
+		truth = cmap.MapTruth(truth)
+		thresholds := make(Thresholds)
+		if *tholdfile != "" {
+			thresholds, err = ReadThresholds(*tholdfile)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		scene := Scene{Name: name, Truth: truth, Detects: detects}
+		boxesAt = func(min float32) ([]Box, []BoxLayer) {
+			accept := func(d Detect) bool {
+				return d.Confidence >= thresholds.Get(d.Class, min)
+			}
+			return reviewBoxes(scene.DropIgnored(float32(*minIou), accept), float32(*minIou), accept, labels)
+		}
+		if *report {
+			accept := func(d Detect) bool {
+				return d.Confidence >= thresholds.Get(d.Class, float32(*minConf))
+			}
+			s := scene.DropIgnored(float32(*minIou), accept)
+			writeReport(s, im, accept, labels, *outdir, float32(*minIou), float32(*minConf), *thumbsize, *quality)
+			return
+		}
+	} else {
+		boxesAt = func(min float32) ([]Box, []BoxLayer) {
+			return classBoxes(detects, min, labels)
+		}
+	}
+
+	if *sweep != "" {
+		confs, err := parseSweep(*sweep)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *framedir != "" {
+			if err := os.MkdirAll(*framedir, 0755); err != nil {
+				log.Fatal(err)
+			}
+		}
+		scale := 1.0
+		if *thumbsize > 0 && (sz.X > *thumbsize || sz.Y > *thumbsize) {
+			scale = float64(*thumbsize) / math.Max(float64(sz.X), float64(sz.Y))
+		}
+		base := image.NewRGBA(image.Rect(0, 0, int(float64(sz.X)*scale), int(float64(sz.Y)*scale)))
+		xdraw.CatmullRom.Scale(base, base.Bounds(), im, im.Bounds(), xdraw.Src, nil)
+
+		frames := make([]image.Image, len(confs))
+		for i, c := range confs {
+			dc := gg.NewContext(base.Bounds().Dx(), base.Bounds().Dy())
+			dc.DrawImage(base, 0, 0)
+			drawChips(dc, chipBoxes(chips, detects, float32(c), *chiplabels), scale, *fontsize)
+			boxes, layers := boxesAt(float32(c))
+			var entries []LegendEntry
+			if *legend {
+				entries = Legend(layers)
 			}
+			dc.SetFontFace(FontFace(*fontsize))
+			DrawBoxes(dc, ScaleBoxes(boxes, scale), 2, mode, LegendBounds(dc, entries))
+			DrawLegend(dc, entries)
+			drawBanner(dc, fmt.Sprintf("confidence %.2f", c))
+			frames[i] = dc.Image()
 		}
+
+		_, ofile, _ := SplitPath(*imagefile)
+		if *framedir != "" {
+			for i, c := range confs {
+				output := filepath.Join(*framedir, fmt.Sprintf("%s-%.2f.jpg", ofile, c))
+				if err := gg.SaveJPG(output, frames[i], *quality); err != nil {
+					log.Fatal(err)
+				}
+			}
+			log.Println(fmt.Sprint("frames written to file://", *framedir))
+			return
+		}
+		output := fmt.Sprintf("%s/%s-sweep.gif", *outdir, ofile)
+		if err := writeGIF(output, frames, *delay); err != nil {
+			log.Fatal(err)
+		}
+		log.Println(fmt.Sprint("rendered to file://", output))
+		return
 	}
 
-	dc.SetLineWidth(2)
-	dc.SetColor(colornames.Red)
-	for _, det := range detects {
-		if det.Confidence > float32(*minConf) {
-			b := det.Bounds
-			dc.DrawRectangle(float64(b.Min.X), float64(b.Min.Y), float64(b.Size().X), float64(b.Size().Y))
-			dc.Stroke()
+	boxes, layers := boxesAt(float32(*minConf))
+
+	if *tiledir != "" {
+		_, title, _ := SplitPath(*imagefile)
+		p, err := NewPyramid(title, sz, *tilesize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		base := PyramidLayer{
+			Name:   "image",
+			Opaque: true,
+			Draw: func(dc *gg.Context, scale float64) {
+				dst := dc.Image().(*image.RGBA)
+				xdraw.CatmullRom.Scale(dst, dst.Bounds(), im, im.Bounds(), xdraw.Src, nil)
+				drawChips(dc, grid, scale, *fontsize)
+			},
+		}
+		if err := WritePyramid(*tiledir, p, append([]PyramidLayer{base}, boxLayers(layers, mode, *fontsize)...), *tileformat, *quality); err != nil {
+			log.Fatal(err)
 		}
+		log.Println(fmt.Sprint("rendered to file://", filepath.Join(*tiledir, "index.html")))
+		return
 	}
 
+	var entries []LegendEntry
+	if *legend {
+		entries = Legend(layers)
+	}
 	_, ofile, _ := SplitPath(*imagefile)
-	output := fmt.Sprintf("%s/%s-detects.jpg", *outdir, ofile)
-	if err := dc.SaveJPG(output, 75); err != nil {
+	suffix := "detects"
+	if *tFile != "" {
+		suffix = "review"
+	}
+	output := fmt.Sprintf("%s/%s-%s.%s", *outdir, ofile, suffix, *format)
+
+	dc := gg.NewContext(sz.X, sz.Y)
+	if *format == "svg" {
+		dc.SetFontFace(FontFace(*fontsize))
+		href, err := JPEGDataURI(im, *thumbsize, *quality)
+		if err != nil {
+			log.Fatal(err)
+		}
+		svg := SVG{Image: href, Size: sz, Grid: grid, Boxes: boxes, Legend: entries, Mode: mode, FontSize: *fontsize}
+		if err := writeSVG(output, svg, dc); err != nil {
+			log.Fatal(err)
+		}
+		log.Println(fmt.Sprint("rendered to file://", output))
+		return
+	}
+
+	dc.DrawImage(im, 0, 0)
+	drawChips(dc, grid, 1, *fontsize)
+
+	dc.SetFontFace(FontFace(*fontsize))
+	DrawBoxes(dc, boxes, 2, mode, LegendBounds(dc, entries))
+	DrawLegend(dc, entries)
+
+	if err := dc.SaveJPG(output, *quality); err != nil {
 		log.Fatalf("%s: %v\n", *pFile, err)
 	}
 	log.Println(fmt.Sprint("rendered to file://", output))
 }
+
+// boxes of the detections above min colored by class, most confident first,
+// and a layer of them for each class
+func classBoxes(detects []Detect, min float32, labels map[CID]string) ([]Box, []BoxLayer) {
+	sorted := make([]Detect, len(detects))
+	copy(sorted, detects)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Confidence > sorted[j].Confidence
+	})
+
+	boxes := make([]Box, 0, len(sorted))
+	byClass := make(map[CID][]Box)
+	for _, det := range sorted {
+		if det.Confidence >= min {
+			b := Box{
+				Bounds:  det.Bounds,
+				Color:   ClassColor(det.Class),
+				Caption: fmt.Sprintf("%s %.2f", LabelName(labels, det.Class), det.Confidence),
+				Attrs:   detectAttrs(det, ""),
+			}
+			boxes = append(boxes, b)
+			byClass[det.Class] = append(byClass[det.Class], b)
+		}
+	}
+
+	classes := make([]int, 0, len(byClass))
+	for c := range byClass {
+		classes = append(classes, int(c))
+	}
+	sort.Ints(classes)
+	layers := make([]BoxLayer, len(classes))
+	for i, c := range classes {
+		layers[i] = BoxLayer{
+			Name:  LabelName(labels, CID(c)),
+			Color: ClassColor(CID(c)),
+			Boxes: byClass[CID(c)],
+		}
+	}
+	return boxes, layers
+}
+
+// the attributes of a detection in svg output, with its outcome in review
+func detectAttrs(d Detect, outcome string) map[string]string {
+	ret := map[string]string{
+		"class":      fmt.Sprint(d.Class),
+		"confidence": fmt.Sprint(d.Confidence),
+	}
+	if outcome != "" {
+		ret["outcome"] = outcome
+	}
+	return ret
+}
+
+func reviewAttrs(r Reviewed) map[string]string {
+	ret := detectAttrs(r.D, r.Outcome.String())
+	ret["truth"] = fmt.Sprint(r.T.Id)
+	ret["truth-class"] = fmt.Sprint(r.T.Class)
+	return ret
+}
+
+// boxes of the outcome of matching the accepted detections of a scene as
+// score does, errors first so they get the captions, and a layer of them for
+// each outcome
+func reviewBoxes(s Scene, minIou float32, accept func(Detect) bool, labels map[CID]string) ([]Box, []BoxLayer) {
+	byOutcome := make(map[Outcome][]Box)
+	for _, r := range ReviewScene(s, minIou, accept) {
+		b := Box{Bounds: r.D.Bounds, Color: r.Outcome.Color()}
+		switch r.Outcome {
+		case OutcomeFP:
+			b.Caption = fmt.Sprintf("%s %.2f", LabelName(labels, r.D.Class), r.D.Confidence)
+			b.Attrs = detectAttrs(r.D, r.Outcome.String())
+		case OutcomeMissed:
+			b.Bounds = r.T.Bounds
+			b.Caption = LabelName(labels, r.T.Class)
+			b.Dash = []float64{4, 3}
+			b.Attrs = map[string]string{"class": fmt.Sprint(r.T.Class), "outcome": r.Outcome.String(), "truth": fmt.Sprint(r.T.Id)}
+		case OutcomeMisclassified:
+			b.Caption = fmt.Sprintf("%s as %s %.2f", LabelName(labels, r.T.Class), LabelName(labels, r.D.Class), r.D.Confidence)
+			b.Attrs = reviewAttrs(r)
+		default:
+			b.Caption = fmt.Sprintf("%s %.2f", LabelName(labels, r.D.Class), r.D.Confidence)
+			b.Attrs = reviewAttrs(r)
+		}
+		byOutcome[r.Outcome] = append(byOutcome[r.Outcome], b)
+	}
+
+	boxes := make([]Box, 0, len(s.Truth)+len(byOutcome[OutcomeFP]))
+	for _, o := range []Outcome{OutcomeFP, OutcomeMisclassified, OutcomeMissed, OutcomeTP} {
+		boxes = append(boxes, byOutcome[o]...)
+	}
+	names := map[Outcome]string{
+		OutcomeTP:            "true positive",
+		OutcomeFP:            "false positive",
+		OutcomeMisclassified: "misclassified",
+		OutcomeMissed:        "missed, dashed",
+	}
+	layers := make([]BoxLayer, len(Outcomes))
+	for i, o := range Outcomes {
+		layers[i] = BoxLayer{Name: names[o], Color: o.Color(), Boxes: byOutcome[o]}
+	}
+	return boxes, layers
+}
+
+// writes the html review of a scene to <outdir>/<scene>-report.html
+func writeReport(s Scene, im image.Image, accept func(Detect) bool, labels map[CID]string, outdir string, minIou, minConf float32, thumbsize, quality int) {
+	r := NewSceneReport(s, minIou, minConf, accept, labels)
+	if err := r.SetImage(im, thumbsize, quality); err != nil {
+		log.Fatal(err)
+	}
+	output := filepath.Join(outdir, s.Name+"-report.html")
+	f, err := os.Create(output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := r.WriteHTML(f); err != nil {
+		log.Fatalf("%s: %v", output, err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Println(fmt.Sprint("report written to file://", output))
+}
+
+// the confidences from start to stop, inclusive, step apart, of start:stop:step
+func parseSweep(s string) ([]float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("sweep must be start:stop:step, not %q", s)
+	}
+	var vs [3]float64
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("sweep must be start:stop:step, not %q", s)
+		}
+		vs[i] = v
+	}
+	start, stop, step := vs[0], vs[1], vs[2]
+	if step <= 0 || stop < start {
+		return nil, fmt.Errorf("sweep must step up from start to stop, not %q", s)
+	}
+	n := int(math.Floor((stop-start)/step+1e-9)) + 1
+	ret := make([]float64, n)
+	for i := range ret {
+		ret[i] = start + float64(i)*step
+	}
+	return ret, nil
+}
+
+// draws text on a white panel in the top right corner
+func drawBanner(dc *gg.Context, text string) {
+	w, h := dc.MeasureString(text)
+	pad := h / 2
+	x := float64(dc.Width()) - w - 3*pad
+	dc.SetRGBA(1, 1, 1, .85)
+	dc.DrawRectangle(x, pad, w+2*pad, h+2*pad)
+	dc.Fill()
+	dc.SetColor(color.Black)
+	dc.DrawStringAnchored(text, x+pad, 2*pad+h/2, 0, .35)
+}
+
+// writes frames as a looping gif in the plan9 palette, without dithering so
+// that what does not change between frames does not flicker
+func writeGIF(output string, frames []image.Image, delay int) error {
+	anim := gif.GIF{}
+	for _, f := range frames {
+		p := image.NewPaletted(f.Bounds(), palette.Plan9)
+		xdraw.Draw(p, p.Bounds(), f, f.Bounds().Min, xdraw.Src)
+		anim.Image = append(anim.Image, p)
+		anim.Delay = append(anim.Delay, delay/10)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(f, &anim); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %v", output, err)
+	}
+	return f.Close()
+}
+
+// the set of classes in a comma separated list, or nil for all when empty
+func parseClasses(list string) (map[CID]bool, error) {
+	if list == "" {
+		return nil, nil
+	}
+	ret := make(map[CID]bool)
+	for _, f := range strings.Split(list, ",") {
+		c, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, fmt.Errorf("class must be a number, not %q", f)
+		}
+		ret[CID(c)] = true
+	}
+	return ret, nil
+}
+
+// writes the density as csv, or a GeoTIFF when the path ends in .tif or
+// .tiff, georeferenced with the tags of imagefile when it is a GeoTIFF
+func writeDensity(path string, d Density, imagefile string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".tif" || ext == ".tiff" {
+		geo, err := ReadGeoTags(imagefile)
+		if err != nil || len(geo) == 0 {
+			log.Printf("WARNING: %s is not a GeoTIFF, %s is not georeferenced", imagefile, path)
+		}
+		values := make([]float32, len(d.Values))
+		for i, v := range d.Values {
+			values[i] = float32(v)
+		}
+		err = WriteGeoTIFF(f, d.Cols, d.Rows, values, geo.Scaled(float64(d.Cell)))
+	} else {
+		err = d.WriteCSV(f)
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return f.Close()
+}
+
+func writeSVG(output string, svg SVG, dc *gg.Context) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := svg.Write(f, dc); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %v", output, err)
+	}
+	return f.Close()
+}
+
+// a transparent layer of the pyramid for each layer of boxes, captioned at
+// every zoom level
+func boxLayers(layers []BoxLayer, mode CaptionMode, fontsize float64) []PyramidLayer {
+	ret := make([]PyramidLayer, len(layers))
+	for i, l := range layers {
+		l := l
+		ret[i] = PyramidLayer{
+			Name:  fmt.Sprintf("%s (%d)", l.Name, len(l.Boxes)),
+			Color: l.Color,
+			Draw: func(dc *gg.Context, scale float64) {
+				dc.SetFontFace(FontFace(fontsize))
+				DrawBoxes(dc, ScaleBoxes(l.Boxes, scale), 2, mode)
+			},
+		}
+	}
+	return ret
+}
+
+// the outline of each chip, captioned when label with its index and the
+// count of detections above min centered in it
+func chipBoxes(chips []image.Rectangle, detects []Detect, min float32, label bool) []Box {
+	ret := make([]Box, len(chips))
+	for i, c := range chips {
+		ret[i] = Box{Bounds: c, Color: colornames.Yellow, Attrs: map[string]string{"chip": fmt.Sprint(i)}}
+		if !label {
+			continue
+		}
+		n := 0
+		for _, d := range detects {
+			center := d.Bounds.Min.Add(d.Bounds.Max).Div(2)
+			if d.Confidence >= min && center.In(c) {
+				n++
+			}
+		}
+		ret[i].Caption = fmt.Sprintf("#%d (%d)", i, n)
+	}
+	return ret
+}
+
+// outlines the chips of the grid on an image drawn at scale, with their
+// captions inside the top left corner
+func drawChips(dc *gg.Context, grid []Box, scale float64, fontsize float64) {
+	dc.SetLineWidth(.5)
+	dc.SetFontFace(FontFace(fontsize))
+	for _, b := range grid {
+		c := b.Bounds
+		dc.SetColor(b.Color)
+		dc.DrawRectangle(float64(c.Min.X)*scale, float64(c.Min.Y)*scale, float64(c.Dx())*scale, float64(c.Dy())*scale)
+		dc.Stroke()
+		if b.Caption != "" {
+			dc.DrawStringAnchored(b.Caption, float64(c.Min.X)*scale+4, float64(c.Min.Y)*scale+4, 0, 1)
+		}
+	}
+}
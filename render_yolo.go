@@ -2,30 +2,50 @@ package main
 
 import (
 	. "./common"
-	"encoding/csv"
 	"flag"
+	"fmt"
 	"github.com/fogleman/gg"
 	"golang.org/x/image/colornames"
+	xdraw "golang.org/x/image/draw"
 	"image"
-	"image/draw"
 	"image/jpeg"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
+// an annotated chip of a mosaic
+type mosaicChip struct {
+	Name   string
+	Thumb  image.Image
+	Labels []YoloLabel
+}
+
 // read a directory of chips and create a mosaic with bounding boxes
 func main() {
 	sourcedir := flag.String("source", "", "Source dir")
 	targetdir := flag.String("target", "", "Output dir")
+	strict := flag.Bool("strict", false, "Fail on malformed lines of labels, rather than skipping them")
+	mosaic := flag.Bool("mosaic", false, "Also tile the annotated chips into pages of a mosaic, mosaic-<page>.jpg")
+	cols := flag.Int("cols", 8, "Chips per row of the mosaic")
+	thumbsize := flag.Int("thumb", 256, "Size each chip is scaled to fit in the mosaic")
+	pagesize := flag.Int("page", 64, "Chips per page of the mosaic, or 0 for a single page")
+	order := flag.String("sort", "name", "Order of chips in the mosaic; name, labels (most first) or class (most labelled)")
+	fontsize := flag.Float64("font-size", 10, "Caption font size in points")
+	quality := flag.Int("quality", 75, "JPEG quality")
 
 	flag.Parse()
 	if *sourcedir == "" || *targetdir == "" {
 		flag.Usage()
 		return
 	}
+	if *order != "name" && *order != "labels" && *order != "class" {
+		log.Fatalf("unknown sort %q, expected name, labels or class", *order)
+	}
 
 	os.Mkdir(*targetdir, 0755)
 
@@ -34,6 +54,7 @@ func main() {
 		log.Fatal(err)
 	}
 
+	var chips []mosaicChip
 	for _, file := range files {
 		if strings.HasSuffix(file.Name(), "txt") {
 			imagename := strings.TrimSuffix(file.Name(), "txt") + "jpg"
@@ -41,36 +62,114 @@ func main() {
 			imagefile := filepath.Join(*sourcedir, imagename)
 			outfile := filepath.Join(*targetdir, imagename)
 
-			RenderChip(labelfile, imagefile, outfile)
+			rendered, labels := RenderChip(labelfile, imagefile, GetParseMode(*strict))
+			if err := gg.SaveJPG(outfile, rendered, *quality); err != nil {
+				log.Fatal(err)
+			}
+			if *mosaic {
+				chips = append(chips, mosaicChip{Name: imagename, Thumb: thumbnail(rendered, *thumbsize), Labels: labels})
+			}
 		}
 	}
+	if !*mosaic {
+		return
+	}
+	if len(chips) == 0 {
+		log.Printf("WARNING: no chips with labels in %s for the mosaic", *sourcedir)
+		return
+	}
+
+	switch *order {
+	case "labels":
+		sort.SliceStable(chips, func(i, j int) bool {
+			return len(chips[i].Labels) > len(chips[j].Labels)
+		})
+	case "class":
+		sort.SliceStable(chips, func(i, j int) bool {
+			ci, ni := majorityClass(chips[i].Labels)
+			cj, nj := majorityClass(chips[j].Labels)
+			if (ni == 0) != (nj == 0) {
+				return nj == 0
+			}
+			return ci < cj
+		})
+	}
+
+	pages := Pages(len(chips), *pagesize)
+	sheet := Sheet{Cell: *thumbsize, Cols: *cols}
+	face := FontFace(*fontsize)
+	for p, r := range pages {
+		page := chips[r[0]:r[1]]
+		ims := make([]image.Image, len(page))
+		captions := make([]string, len(page))
+		for i, c := range page {
+			ims[i] = c.Thumb
+			captions[i] = fmt.Sprintf("%s (%d)", c.Name, len(c.Labels))
+		}
+		title := fmt.Sprintf("%s page %d of %d, chips %d-%d of %d", filepath.Base(*sourcedir), p+1, len(pages), r[0]+1, r[1], len(chips))
+		output := filepath.Join(*targetdir, fmt.Sprintf("mosaic-%d.jpg", p+1))
+		if err := gg.SaveJPG(output, sheet.Mosaic(ims, captions, title, face), *quality); err != nil {
+			log.Fatal(err)
+		}
+		log.Println(fmt.Sprint("mosaic written to file://", output))
+	}
 }
 
-func RenderChip(labelfile, imagefile, outfile string) {
+// the class most of the labels are of, the lowest on a tie, and how many
+func majorityClass(labels []YoloLabel) (CID, int) {
+	counts := make(map[CID]int)
+	for _, l := range labels {
+		counts[l.Class]++
+	}
+	var ret CID
+	n := 0
+	for c, k := range counts {
+		if k > n || (k == n && c < ret) {
+			ret, n = c, k
+		}
+	}
+	return ret, n
+}
+
+// the image scaled down to fit size, if it is larger
+func thumbnail(im image.Image, size int) image.Image {
+	b := im.Bounds()
+	if size <= 0 || (b.Dx() <= size && b.Dy() <= size) {
+		return im
+	}
+	s := math.Min(float64(size)/float64(b.Dx()), float64(size)/float64(b.Dy()))
+	ret := image.NewRGBA(image.Rect(0, 0, int(float64(b.Dx())*s), int(float64(b.Dy())*s)))
+	xdraw.CatmullRom.Scale(ret, ret.Bounds(), im, b, xdraw.Src, nil)
+	return ret
+}
+
+// RenderChip draws the boxes of the yolo labels of a chip over it
+func RenderChip(labelfile, imagefile string, mode ParseMode) (image.Image, []YoloLabel) {
 	file, err := os.Open(imagefile)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
+	defer file.Close()
 
 	im, err := jpeg.Decode(file)
 	if err != nil {
 		log.Fatalf("%s: %v\n", imagefile, err)
 	}
 
-	f, _ := os.Open(labelfile)
-	csvr := csv.NewReader(f)
-	csvr.Comma = ' '
-	csv, _ := csvr.ReadAll()
-	labels := ReadYoloLabels(csv)
-
-	rendered := image.NewRGBA(im.Bounds())
-	draw.Draw(rendered, im.Bounds(), im, image.ZP, draw.Src)
+	f, err := os.Open(labelfile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer f.Close()
+	labels, err := ReadYoloLabels(f, labelfile, mode)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 
 	sz := im.Bounds().Size()
 	dc := gg.NewContext(sz.X, sz.Y)
 
 	dc.DrawImage(im, 0, 0)
-	dc.SetColor(colornames.Red)
 
 	dc.SetLineWidth(2)
 	dc.SetColor(colornames.Red)
@@ -79,7 +178,7 @@ func RenderChip(labelfile, imagefile, outfile string) {
 		dc.DrawRectangle(float64(b.Min.X), float64(b.Min.Y), float64(b.Size().X), float64(b.Size().Y))
 		dc.Stroke()
 	}
-	dc.SaveJPG(outfile, 75)
+	return dc.Image(), labels
 }
 
 func YoloToRect(label YoloLabel, size image.Point) image.Rectangle {
@@ -2,122 +2,352 @@ package main
 
 import (
 	. "./common"
-	"encoding/csv"
 	"encoding/json"
 	"flag"
-	"image"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"text/tabwriter"
 )
 
-type FeatureCollection struct {
-	Features []Feature
-}
-
-type Feature struct {
-	Properties struct {
-		Id     int    `json:"feature_id"`
-		Bounds string `json:"bounds_imcoords"`
-		Class  int    `json:"type_id"`
-	}
-}
-
 type Stats struct {
 	GroundTruthClasses map[CID]int
 	AveragePrecision   map[CID]float32
 }
 
 func main() {
-	pFile := flag.String("predictions", "", "Path to predictions csv")
-	tFile := flag.String("groundtruth", "", "Path to ground-truth geojson")
+	pFile := flag.String("predictions", "", "Path to predictions csv, or dir of <scene>.txt")
+	tFile := flag.String("groundtruth", "", "Path to ground-truth geojson, or dir of <scene>.geojson")
 	minIou := flag.Float64("iou", .5, "IOU threshold")
 	minConf := flag.Float64("confidence", .5, "Confidence threshold")
+	labelfile := flag.String("labels", "labels.txt", "Path of a class mapping dict")
+	curvedir := flag.String("curves", "", "Dir to write per-class precision-recall curves")
+	curveformat := flag.String("curve-format", "png", "Format of the precision-recall plots of -curves; png or svg")
+	tholdfile := flag.String("thresholds", "", "Path to per-class confidence thresholds, overriding -confidence")
+	sweepfile := flag.String("sweep", "", "Path to write per-class confidence thresholds found by sweeping")
+	sweepMin := flag.Float64("sweep-min", .05, "Lowest confidence to sweep")
+	sweepMax := flag.Float64("sweep-max", .95, "Highest confidence to sweep")
+	sweepStep := flag.Float64("sweep-step", .05, "Confidence increment to sweep by")
+	beta := flag.Float64("beta", 1, "Sweep for the F-beta score, where recall is weighted beta times precision")
+	errmode := flag.Bool("errors", false, "Break down errors by type and the mAP each costs")
+	bgIou := flag.Float64("bg-iou", .1, "IOU below which an error is considered background")
+	samples := flag.Int("bootstrap", 0, "Number of bootstrap resamples of the scenes for confidence intervals, 0 to disable")
+	level := flag.Float64("ci", .95, "Confidence level of bootstrap intervals")
+	seed := flag.Int64("seed", 1, "Random seed for bootstrap resampling")
+	reportfile := flag.String("report", "", "Path to write the scores as json")
+	cmfile := flag.String("cm", "", "Path to write the confusion matrix as csv")
+	heatmap := flag.String("heatmap", "", "Path to write the confusion matrix as a png heatmap")
+	normalize := flag.Bool("normalize", false, "Normalize confusion matrix rows to fractions of the row")
+	undefined := flag.String("undefined", "skip", "Handling of metrics that divide by zero; na, zero, or skip in averages")
+
+	shardfile := flag.String("shard", "", "Path to write the per-scene scores as json, to be merged later")
+	mergefiles := flag.String("merge", "", "Comma separated shard files to merge and score, in place of predictions")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of scenes to score in parallel")
+	hierfile := flag.String("hierarchy", "", "Path to a class hierarchy, eg. hierarchy.txt, to score at -level")
+	hierLevel := flag.Int("level", 0, "Hierarchy level to score at, 0 for the top; thresholds apply to classes at this level")
+	cmapfile := flag.String("classmap", "", "Path to `class target' lines remapping classes of predictions and ground truth, where target may be ignore for don't care")
+	ignorefile := flag.String("ignore", "", "Path to a geojson of regions, eg. cloud cover, where truth and detections are don't care")
+	minIoa := flag.Float64("ignore-ioa", .5, "Fraction of a box inside an ignore region for it to be don't care")
+	minArea := flag.Int("min-area", 0, "Truth smaller than this many pixels, eg. degenerate or clipped boxes, is don't care")
+	strict := flag.Bool("strict", false, "Fail on malformed lines of predictions and labels or ground truth features, rather than skipping them")
 
 	flag.Parse()
-	if *pFile == "" || *tFile == "" {
+	if (*pFile == "" || *tFile == "") && *mergefiles == "" {
 		flag.Usage()
 		return
 	}
 
-	_, err := ioutil.ReadFile(*pFile)
+	if *curveformat != "png" && *curveformat != "svg" {
+		log.Fatalf("curve format must be png or svg, not %q", *curveformat)
+	}
+	u, err := ParseUndefined(*undefined)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	f, _ := os.Open(*pFile)
-	csvr := csv.NewReader(f)
-	csvr.Comma = ' '
-	predictions, _ := csvr.ReadAll()
-
-	tbytes, err := ioutil.ReadFile(*tFile)
+	labels, err := ReadLabels(*labelfile, GetParseMode(*strict))
 	if err != nil {
-		log.Fatal(err)
+		if *strict {
+			log.Fatalf("%s: %v", *labelfile, err)
+		}
+		log.Printf("%s: %v", *labelfile, err)
+	}
+	var hier Hierarchy
+	if *hierfile != "" {
+		hier, err = ReadHierarchy(*hierfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		labels = hier.Labels(labels)
 	}
 
-	var ref FeatureCollection
-	json.Unmarshal(tbytes, &ref)
+	var scenes []Scene
+	var shard Shard
+	if *mergefiles != "" {
+		if *hierfile != "" {
+			log.Println("WARNING: shards are merged at the level they were scored, -hierarchy only names classes")
+		}
+		for _, f := range strings.Split(*mergefiles, ",") {
+			s, err := ReadShard(f)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := shard.Merge(s); err != nil {
+				log.Fatalf("%s: %v", f, err)
+			}
+		}
+		*minIou = float64(shard.IoU)
+	} else {
+		scenes, err = LoadScenes(*pFile, *tFile, GetParseMode(*strict))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *cmapfile != "" {
+			cmap, err := ReadClassMap(*cmapfile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			scenes = cmap.Apply(scenes)
+		}
+		if *hierfile != "" {
+			scenes = hier.RollUp(scenes, *hierLevel)
+		}
+		if *ignorefile != "" {
+			regions, err := ReadRegions(*ignorefile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for i := range scenes {
+				scenes[i] = scenes[i].IgnoreRegions(regions, float32(*minIoa))
+			}
+		}
+		thresholds := make(Thresholds)
+		if *tholdfile != "" {
+			thresholds, err = ReadThresholds(*tholdfile)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		accept := func(d Detect) bool {
+			return d.Confidence >= thresholds.Get(d.Class, float32(*minConf))
+		}
+		for i := range scenes {
+			for j, t := range scenes[i].Truth {
+				if Area(t.Bounds) < *minArea {
+					scenes[i].Truth[j].Ignore = true
+				}
+			}
+			scenes[i] = scenes[i].DropIgnored(float32(*minIou), accept)
+		}
+		shard = scoreScenes(scenes, float32(*minIou), float32(*minConf), thresholds, *workers)
+	}
 
-	gtc := make(map[CID]int)
-	detects := ReadDetects(predictions)
+	if *shardfile != "" {
+		if err := shard.Write(*shardfile); err != nil {
+			log.Fatal(err)
+		}
+		log.Println(fmt.Sprint("shard written to file://", *shardfile))
+	}
 
-	truth := make([]Truth, len(ref.Features))
-	for i, rf := range ref.Features {
-		splits := strings.Split(rf.Properties.Bounds, ",")
-		truth[i] = Truth{
-			Id: TID(rf.Properties.Id),
-			Bounds: SplitToRect(splits),
-			Class: CID(rf.Properties.Class),
+	cms := shard.Matrices()
+	results := shard.Results()
+	ntruth, ndetects := 0, 0
+	for _, r := range results {
+		for _, cnt := range r.Truth {
+			ntruth += cnt
 		}
+		ndetects += len(r.Scored)
+	}
 
-		gtc[CID(rf.Properties.Class)]++
+	cm := shard.Matrix()
+	curves := GetSceneCurves(results)
+	report := GetReport(cm, curves, labels, u)
+	if *samples > 0 {
+		if len(results) < 2 {
+			log.Println("WARNING: bootstrap over a single scene is degenerate")
+		}
+		rnd := rand.New(rand.NewSource(*seed))
+		ci, mapci := BootstrapIntervals(cms, results, *samples, *level, u, rnd)
+		report.SetIntervals(ci, mapci, *level, *samples)
 	}
 
-	matched := make(map[TID]Match, len(truth))
-	unmatched := make(map[CID]int)
-	fds := make([]Detect, 0)
+	println(ntruth)
+	println(ndetects)
+	println(report.Summary())
 
-	for _, d := range detects {
-		if d.Confidence >= float32(*minConf) {
-			found := false
-			for _, t := range truth {
-				if _, here := matched[t.Id]; !here {
-					i := t.Bounds.Intersect(d.Bounds)
+	if *reportfile != "" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(*reportfile, b, 0644); err != nil {
+			log.Fatal(err)
+		}
+		log.Println(fmt.Sprint("report written to file://", *reportfile))
+	}
 
-					// calculate IOU if overlapping
-					if !i.Empty() {
-						ia := area(i)
-						id := area(d.Bounds)
-						it := area(t.Bounds)
-						iou := float32(ia) / float32(id+it-ia)
+	if *cmfile != "" {
+		out, err := os.Create(*cmfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+		if err := WriteConfusionMatrix(out, cm, labels, *normalize); err != nil {
+			log.Fatal(err)
+		}
+		log.Println(fmt.Sprint("confusion matrix written to file://", *cmfile))
+	}
 
-						if iou >= float32(*minIou) {
-							matched[t.Id] = Match{T: t, D: d, IoU: iou}
-							found = true
-							break
-						}
-					}
+	if *heatmap != "" {
+		if err := PlotConfusionMatrix(cm, labels, *normalize, *heatmap); err != nil {
+			log.Fatal(err)
+		}
+		log.Println(fmt.Sprint("heatmap written to file://", *heatmap))
+	}
+
+	if *errmode {
+		if scenes == nil {
+			log.Println("WARNING: -errors requires predictions and ground truth, not shards")
+		} else {
+			println(GetErrorSummary(AnalyzeErrors(scenes, float32(*minIou), float32(*bgIou))))
+		}
+	}
+
+	if *curvedir != "" {
+		if err := writeCurves(*curvedir, results, labels, *curveformat); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *sweepfile != "" {
+		if *sweepStep <= 0 || *sweepMin > *sweepMax {
+			log.Fatalf("invalid sweep range %v:%v:%v", *sweepMin, *sweepStep, *sweepMax)
+		}
+		swept := sweepThresholds(curves, *sweepMin, *sweepMax, *sweepStep, *beta, labels)
+
+		out, err := os.Create(*sweepfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+		fmt.Fprintf(out, "# per-class confidence thresholds maximizing F%v at iou %v\n", *beta, *minIou)
+		if err := swept.Write(out); err != nil {
+			log.Fatal(err)
+		}
+		log.Println(fmt.Sprint("thresholds written to file://", *sweepfile))
+	}
+}
+
+// scores each scene in parallel, accepting detections for the confusion
+// matrix at the per-class threshold or else the confidence
+func scoreScenes(scenes []Scene, minIou, minConf float32, thresholds Thresholds, workers int) Shard {
+	shard := Shard{
+		IoU:        minIou,
+		Confidence: minConf,
+		Thresholds: thresholds,
+		Scenes:     make([]SceneScore, len(scenes)),
+	}
+	accept := func(d Detect) bool {
+		return d.Confidence >= thresholds.Get(d.Class, minConf)
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				s := scenes[i]
+				shard.Scenes[i] = SceneScore{
+					Matrix: GetSceneConfusionMatrix(s, minIou, accept),
+					Result: ScoreScene(s.Name, s.Truth, s.Detects, minIou),
 				}
 			}
+		}()
+	}
+	for i := range scenes {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
 
-			if !found {
-				// false-positive due to non intersecting box
-				// todo;; could also be a duplicate
-				fds = append(fds, d)
-				unmatched[d.Class]++
-			}
-		}
+	return shard
+}
+
+// finds the best confidence threshold for each class, printing a table of the results
+func sweepThresholds(curves map[CID]PRCurve, min, max, step, beta float64, labels map[CID]string) Thresholds {
+	keys := make([]int, 0, len(curves))
+	for k := range curves {
+		keys = append(keys, int(k))
 	}
+	sort.Ints(keys)
 
-	cm, _ := GetConfusionMatrix(gtc, matched, unmatched)
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 0, '\t', 0)
+	fmt.Fprintf(w, "Class\tName\tThreshold\tPrecision\tRecall\tF%v\n", beta)
+	fmt.Fprintln(w, "-----\t----\t---------\t---------\t------\t--")
 
-	println(len(ref.Features))
-	println(len(predictions))
-	println(GetSummary(cm))
+	ret := make(Thresholds, len(keys))
+	for _, ik := range keys {
+		k := CID(ik)
+		r := curves[k].Sweep(min, max, step, beta)
+		ret[k] = r.Confidence
+		fmt.Fprintf(w, "%v\t%v\t%v\t%.3f\t%.3f\t%.4f\n", k, LabelName(labels, k), r.Confidence, r.Precision, r.Recall, r.Score)
+	}
+	w.Flush()
+	return ret
 }
 
-func area(r image.Rectangle) int {
-	z := r.Size()
-	return z.X * z.Y
+// writes a csv and a png or svg precision-recall curve for each class and
+// overall
+func writeCurves(outdir string, results []SceneResult, labels map[CID]string, format string) error {
+	if err := os.MkdirAll(outdir, 0755); err != nil {
+		return err
+	}
+
+	curves := GetSceneCurves(results)
+	keys := make([]int, 0, len(curves))
+	for k := range curves {
+		keys = append(keys, int(k))
+	}
+	sort.Ints(keys)
+
+	write := func(name, title string, curve PRCurve) error {
+		f, err := os.Create(filepath.Join(outdir, name+".csv"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := curve.WriteCSV(f); err != nil {
+			return err
+		}
+		return PlotPRCurve(curve, title, filepath.Join(outdir, name+"."+format))
+	}
+
+	for _, ik := range keys {
+		k := CID(ik)
+		if err := write(fmt.Sprintf("pr-%v", k), LabelName(labels, k), curves[k]); err != nil {
+			return err
+		}
+		log.Printf("AP %v: %.4f", LabelName(labels, k), curves[k].AveragePrecision())
+	}
+
+	overall := GetOverallSceneCurve(results)
+	if err := write("pr-all", "All classes", overall); err != nil {
+		return err
+	}
+	log.Printf("AP overall: %.4f", overall.AveragePrecision())
+	log.Println(fmt.Sprint("curves written to file://", outdir))
+	return nil
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	. "./common"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// check an xview ground-truth geojson for problems and optionally write a cleaned copy
+func main() {
+	tFile := flag.String("groundtruth", "", "Path to ground-truth geojson")
+	labelfile := flag.String("labels", "labels.txt", "Path of a class mapping dict, empty to not check classes")
+	imagedir := flag.String("images", "", "Dir of the images named by image_id, to check bounds against their extents")
+	outfile := flag.String("out", "", "Path to write the geojson without the problem features and with fixed bounds")
+	quiet := flag.Bool("quiet", false, "Only print the count of each problem")
+	strict := flag.Bool("strict", false, "Fail on malformed lines of labels, rather than skipping them")
+
+	flag.Parse()
+	if *tFile == "" {
+		flag.Usage()
+		return
+	}
+
+	src, err := ioutil.ReadFile(*tFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var fc FeatureCollection
+	if err := json.Unmarshal(src, &fc); err != nil {
+		log.Fatalf("%s: %v", *tFile, err)
+	}
+
+	var labels map[CID]string
+	if *labelfile != "" {
+		labels, err = ReadLabels(*labelfile, GetParseMode(*strict))
+		if err != nil {
+			log.Fatalf("%s: %v", *labelfile, err)
+		}
+	}
+
+	v := ValidateFeatures(fc, labels, imageExtents(*imagedir))
+	if !*quiet {
+		for _, i := range v.Issues {
+			fmt.Println(i)
+		}
+	}
+
+	var buffer bytes.Buffer
+	w := new(tabwriter.Writer)
+	w.Init(&buffer, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Problem\tFeatures")
+	fmt.Fprintln(w, "-------\t--------")
+	counts := v.Counts()
+	for _, p := range Problems {
+		fmt.Fprintf(w, "%v\t%v\n", p, counts[p])
+	}
+	w.Flush()
+	println(buffer.String())
+	kept := 0
+	for _, k := range v.Keep {
+		if k {
+			kept++
+		}
+	}
+	println(fmt.Sprintf("%v of %v features kept", kept, len(v.Keep)))
+
+	if *outfile != "" {
+		out, err := os.Create(*outfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+		if err := v.WriteClean(out, src); err != nil {
+			log.Fatal(err)
+		}
+		log.Println(fmt.Sprint("cleaned geojson written to file://", *outfile))
+	} else if len(v.Issues) > 0 {
+		os.Exit(1)
+	}
+}
+
+// looks up the size of images in dir by image_id, remembering each
+func imageExtents(dir string) func(string) (image.Rectangle, bool) {
+	cache := make(map[string]*image.Rectangle)
+	return func(name string) (image.Rectangle, bool) {
+		if dir == "" || name == "" {
+			return image.Rectangle{}, false
+		}
+		if r, ok := cache[name]; ok {
+			return extentOf(r)
+		}
+		cache[name] = nil
+
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			log.Printf("WARNING: not checking extents of %s: %v", name, err)
+			return image.Rectangle{}, false
+		}
+		defer f.Close()
+		cfg, _, err := image.DecodeConfig(f)
+		if err != nil {
+			log.Printf("WARNING: not checking extents of %s: %v", name, err)
+			return image.Rectangle{}, false
+		}
+		r := image.Rect(0, 0, cfg.Width, cfg.Height)
+		cache[name] = &r
+		return r, true
+	}
+}
+
+func extentOf(r *image.Rectangle) (image.Rectangle, bool) {
+	if r == nil {
+		return image.Rectangle{}, false
+	}
+	return *r, true
+}